@@ -0,0 +1,260 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/tsutil"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// AuditOp is an audited keyring operation.
+type AuditOp string
+
+// Audit operations.
+const (
+	AuditSetup     AuditOp = "setup"
+	AuditUnlock    AuditOp = "unlock"
+	AuditLock      AuditOp = "lock"
+	AuditRegister  AuditOp = "register"
+	AuditRevoke    AuditOp = "revoke"
+	AuditVerify    AuditOp = "verify"
+	AuditKeyCreate AuditOp = "key-create"
+	AuditKeyUpdate AuditOp = "key-update"
+	AuditKeyRemove AuditOp = "key-remove"
+	AuditKeyRead   AuditOp = "key-read"
+	AuditKeyUse    AuditOp = "key-use"
+	AuditConfig    AuditOp = "config"
+
+	AuditSecretCreate AuditOp = "secret-create"
+	AuditSecretUpdate AuditOp = "secret-update"
+	AuditSecretRemove AuditOp = "secret-remove"
+	AuditSecretRead   AuditOp = "secret-read"
+
+	AuditBlobPut    AuditOp = "blob-put"
+	AuditBlobGet    AuditOp = "blob-get"
+	AuditBlobLink   AuditOp = "blob-link"
+	AuditBlobRemove AuditOp = "blob-remove"
+)
+
+// AuditEntry is an entry in the audit log.
+// Each entry includes the hash of the previous entry, so changes to the log
+// can be detected with VerifyAuditLog.
+// For secrets, KID is the secret ID.
+// For blobs, Detail is the blob name and KID is the linked key (if any).
+type AuditEntry struct {
+	Seq       int64   `msgpack:"seq" db:"seq"`
+	Timestamp int64   `msgpack:"ts" db:"ts"`
+	Op        AuditOp `msgpack:"op" db:"op"`
+	KID       keys.ID `msgpack:"kid,omitempty" db:"kid"`
+	AuthID    string  `msgpack:"authID,omitempty" db:"authID"`
+	AuthType  string  `msgpack:"authType,omitempty" db:"authType"`
+	Actor     string  `msgpack:"actor,omitempty" db:"actor"`
+	Detail    string  `msgpack:"detail,omitempty" db:"detail"`
+	Prev      []byte  `msgpack:"prev,omitempty" db:"prev"`
+	Hash      []byte  `msgpack:"-" db:"hash"`
+}
+
+// AuditFilter for AuditLog.
+// Empty fields match all entries.
+type AuditFilter struct {
+	Op    AuditOp
+	KID   keys.ID
+	Actor string
+	Since time.Time
+	Until time.Time
+	// Limit to the most recent entries.
+	Limit int
+}
+
+// ErrAuditLogInvalid if the audit log was modified.
+var ErrAuditLogInvalid = errors.New("audit log is invalid")
+
+// SetActor sets the actor recorded in audit log entries.
+func (k *Keyring) SetActor(actor string) {
+	k.actor = actor
+}
+
+// AuditLog returns audit log entries matching the filter, oldest first.
+// Requires Unlock.
+func (k *Keyring) AuditLog(filter AuditFilter) ([]*AuditEntry, error) {
+	if k.db == nil {
+		return nil, ErrLocked
+	}
+	return getAuditLog(k.db, filter)
+}
+
+// VerifyAuditLog checks the audit log hash chain, where each entry (and the
+// last entry) is MAC'ed with a key derived from the master key.
+// Returns ErrAuditLogInvalid if the log was modified, including if entries
+// were removed from the end.
+// Requires Unlock.
+func (k *Keyring) VerifyAuditLog() error {
+	if k.db == nil || k.auditKey == nil {
+		return ErrLocked
+	}
+	entries, err := getAuditLog(k.db, AuditFilter{})
+	if err != nil {
+		return err
+	}
+	var prev []byte
+	var seq int64
+	for _, entry := range entries {
+		if entry.Seq != seq+1 {
+			return errors.Wrapf(ErrAuditLogInvalid, "missing entry %d", seq+1)
+		}
+		if !bytes.Equal(entry.Prev, prev) {
+			return errors.Wrapf(ErrAuditLogInvalid, "invalid previous hash at %d", entry.Seq)
+		}
+		hash, err := auditHash(entry, k.auditKey)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal(entry.Hash, hash) {
+			return errors.Wrapf(ErrAuditLogInvalid, "invalid hash at %d", entry.Seq)
+		}
+		prev = entry.Hash
+		seq = entry.Seq
+	}
+	head, err := getConfigBytes(k.db, "auditHead")
+	if err != nil {
+		return err
+	}
+	if len(head) == 0 && seq == 0 {
+		return nil
+	}
+	if !hmac.Equal(head, auditHead(seq, prev, k.auditKey)) {
+		return errors.Wrapf(ErrAuditLogInvalid, "invalid head at %d", seq)
+	}
+	return nil
+}
+
+func (k *Keyring) audit(entry *AuditEntry, reg *auth.Auth) error {
+	return k.auditDB(k.db, k.auditKey, entry, reg)
+}
+
+// auditDB adds an entry to the audit log of db, which may not be the open vault
+// yet (on setup or unlock).
+func (k *Keyring) auditDB(db *sqlx.DB, key *[32]byte, entry *AuditEntry, reg *auth.Auth) error {
+	if reg != nil {
+		entry.AuthID = reg.ID
+		entry.AuthType = string(reg.Type)
+	}
+	entry.Actor = k.actor
+	return Transact(db, func(tx *sqlx.Tx) error {
+		return auditTx(tx, key, k.clock, entry)
+	})
+}
+
+// auditKey is the key for audit log MACs, so the log can't be rewritten
+// without the master key.
+func auditKey(mk *[32]byte) *[32]byte {
+	return keys.Bytes32(keys.HKDFSHA256(mk[:], 32, nil, []byte("keyring/audit")))
+}
+
+// auditHash is a MAC of the entry (including the previous hash).
+func auditHash(entry *AuditEntry, key *[32]byte) ([]byte, error) {
+	b, err := msgpack.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, key[:])
+	_, _ = h.Write(b)
+	return h.Sum(nil), nil
+}
+
+// auditHead is a MAC of the last entry (seq and hash), so removing entries
+// from the end of the log can be detected.
+func auditHead(seq int64, hash []byte, key *[32]byte) []byte {
+	h := hmac.New(sha256.New, key[:])
+	_, _ = h.Write([]byte("keyring/audit/head"))
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(seq))
+	_, _ = h.Write(b)
+	_, _ = h.Write(hash)
+	return append(b, h.Sum(nil)...)
+}
+
+func auditTx(tx *sqlx.Tx, key *[32]byte, clock tsutil.Clock, entry *AuditEntry) error {
+	if key == nil {
+		return ErrLocked
+	}
+	var last AuditEntry
+	if err := tx.Get(&last, "SELECT * FROM audit ORDER BY seq DESC LIMIT 1"); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+	entry.Seq = last.Seq + 1
+	entry.Prev = last.Hash
+	if entry.Timestamp == 0 {
+		entry.Timestamp = clock.NowMillis()
+	}
+	hash, err := auditHash(entry, key)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	logger.Debugf("Audit %s %s", entry.Op, entry.KID)
+	if _, err := tx.NamedExec(`INSERT INTO audit VALUES
+		(:seq, :ts, :op, :kid, :authID, :authType, :actor, :detail, :prev, :hash)`, entry); err != nil {
+		return err
+	}
+	return setConfigBytesTx(tx, "auditHead", auditHead(entry.Seq, entry.Hash, key))
+}
+
+func getAuditLog(db *sqlx.DB, filter AuditFilter) ([]*AuditEntry, error) {
+	where := []string{}
+	args := []interface{}{}
+	if filter.Op != "" {
+		where = append(where, "op = ?")
+		args = append(args, filter.Op)
+	}
+	if filter.KID != "" {
+		where = append(where, "kid = ?")
+		args = append(args, filter.KID)
+	}
+	if filter.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "ts >= ?")
+		args = append(args, tsutil.Millis(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "ts < ?")
+		args = append(args, tsutil.Millis(filter.Until))
+	}
+	query := "SELECT * FROM audit"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY seq DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	var out []*AuditEntry
+	if err := db.Select(&out, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// Reverse to oldest first
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
@@ -0,0 +1,144 @@
+package keyring_test
+
+import (
+	"testing"
+
+	"github.com/getchill-app/keyring"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/api"
+	"github.com/keys-pub/keys/tsutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyring(t)
+	defer closeFn()
+
+	kr.SetActor("alice")
+
+	_, err = kr.SetupPassword("testpassword")
+	require.NoError(t, err)
+
+	sk := keys.GenerateEdX25519Key()
+	err = kr.Set(api.NewKey(sk))
+	require.NoError(t, err)
+	err = kr.Set(api.NewKey(sk).WithLabels("test"))
+	require.NoError(t, err)
+	_, err = kr.Key(sk.ID())
+	require.NoError(t, err)
+	err = kr.Config().Set("key1", "val1")
+	require.NoError(t, err)
+	err = kr.Remove(sk.ID())
+	require.NoError(t, err)
+
+	err = kr.Lock()
+	require.NoError(t, err)
+	_, err = kr.AuditLog(keyring.AuditFilter{})
+	require.EqualError(t, err, "keyring is locked")
+
+	_, err = kr.UnlockWithPassword("testpassword")
+	require.NoError(t, err)
+
+	entries, err := kr.AuditLog(keyring.AuditFilter{})
+	require.NoError(t, err)
+	ops := []keyring.AuditOp{}
+	for _, entry := range entries {
+		ops = append(ops, entry.Op)
+		require.Equal(t, "alice", entry.Actor)
+	}
+	require.Equal(t, []keyring.AuditOp{
+		keyring.AuditSetup,
+		keyring.AuditKeyCreate,
+		keyring.AuditKeyUpdate,
+		keyring.AuditKeyRead,
+		keyring.AuditConfig,
+		keyring.AuditKeyRemove,
+		keyring.AuditLock,
+		keyring.AuditUnlock,
+	}, ops)
+	require.Equal(t, "password", entries[0].AuthType)
+	require.NotEmpty(t, entries[0].AuthID)
+	require.Equal(t, entries[0].AuthID, entries[7].AuthID)
+	require.Equal(t, "key1", entries[4].Detail)
+
+	entries, err = kr.AuditLog(keyring.AuditFilter{KID: sk.ID()})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(entries))
+
+	entries, err = kr.AuditLog(keyring.AuditFilter{Op: keyring.AuditKeyRead})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(entries))
+
+	entries, err = kr.AuditLog(keyring.AuditFilter{Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, keyring.AuditLock, entries[0].Op)
+
+	err = kr.VerifyAuditLog()
+	require.NoError(t, err)
+
+	// Tamper
+	_, err = kr.DB().Exec("UPDATE audit SET actor = 'mallory' WHERE seq = 2")
+	require.NoError(t, err)
+	err = kr.VerifyAuditLog()
+	require.EqualError(t, err, "invalid hash at 2: audit log is invalid")
+
+	_, err = kr.DB().Exec("UPDATE audit SET actor = 'alice' WHERE seq = 2")
+	require.NoError(t, err)
+	_, err = kr.DB().Exec("DELETE FROM audit WHERE seq = 3")
+	require.NoError(t, err)
+	err = kr.VerifyAuditLog()
+	require.EqualError(t, err, "missing entry 3: audit log is invalid")
+}
+
+func TestAuditLogTruncate(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyring(t)
+	defer closeFn()
+
+	_, err = kr.SetupPassword("testpassword")
+	require.NoError(t, err)
+	sk := keys.GenerateEdX25519Key()
+	err = kr.Set(api.NewKey(sk))
+	require.NoError(t, err)
+	err = kr.Remove(sk.ID())
+	require.NoError(t, err)
+	err = kr.VerifyAuditLog()
+	require.NoError(t, err)
+
+	// Removing entries from the end
+	_, err = kr.DB().Exec("DELETE FROM audit WHERE seq = 3")
+	require.NoError(t, err)
+	err = kr.VerifyAuditLog()
+	require.EqualError(t, err, "invalid head at 2: audit log is invalid")
+}
+
+func TestAuditLogClock(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyring(t)
+	defer closeFn()
+	clock := tsutil.NewTestClock()
+	kr.SetClock(clock)
+
+	_, err = kr.SetupPassword("testpassword")
+	require.NoError(t, err)
+	sk := keys.GenerateEdX25519Key()
+	err = kr.Set(api.NewKey(sk))
+	require.NoError(t, err)
+	err = kr.Set(api.NewKey(sk).WithLabels("test"))
+	require.NoError(t, err)
+
+	entries, err := kr.AuditLog(keyring.AuditFilter{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(entries))
+	// Timestamps are from the test clock
+	for _, entry := range entries {
+		require.True(t, entry.Timestamp < clock.NowMillis())
+	}
+	history, err := kr.History(sk.ID())
+	require.NoError(t, err)
+	require.Equal(t, 1, len(history))
+	require.True(t, history[0].ReplacedAt < clock.NowMillis())
+}
@@ -1,6 +1,10 @@
 package api
 
-import "time"
+import (
+	"time"
+
+	"github.com/keys-pub/keys"
+)
 
 // Auth describes an auth method encrypting a master key.
 type Auth struct {
@@ -13,7 +17,7 @@ type Auth struct {
 	// Type of auth
 	Type Type `msgpack:"type,omitempty" db:"type"`
 
-	// Salt (for PasswordAuth and FIDO2HMACSecretAuth)
+	// Salt (for PasswordAuth, FIDO2HMACSecretAuth and KeyFileAuth)
 	Salt []byte `msgpack:"salt,omitempty" db:"salt"`
 
 	// AAGUID (for FIDO2HMACSecretAuth)
@@ -21,7 +25,49 @@ type Auth struct {
 	// NoPin (for FIDO2HMACSecretAuth)
 	NoPin bool `msgpack:"nopin,omitempty" db:"nopin"`
 
+	// KID is the public key the master key is sealed to (for X25519Auth).
+	KID keys.ID `msgpack:"kid,omitempty" db:"kid"`
+
+	// Fingerprint identifies the expected key file (for KeyFileAuth).
+	Fingerprint []byte `msgpack:"fingerprint,omitempty" db:"fingerprint"`
+
+	// ExpiresAt is when the auth expires (in milliseconds), or 0 if it doesn't
+	// expire (for TokenAuth).
+	ExpiresAt int64 `msgpack:"expiresAt,omitempty" db:"expiresAt"`
+
+	// Factors describe the factors (for MultiFactorAuth).
+	Factors Factors `msgpack:"factors,omitempty" db:"factors"`
+
+	// Threshold is the number of shares required to unlock (for ShamirAuth).
+	Threshold int `msgpack:"threshold,omitempty" db:"threshold"`
+	// Shares describe the shares (for ShamirAuth).
+	Shares Shares `msgpack:"shares,omitempty" db:"shares"`
+
+	// KDF is the key derivation function (for PasswordAuth).
+	// If empty, the default (argon2id) parameters are used.
+	KDF string `msgpack:"kdf,omitempty" db:"kdf"`
+	// KDFTime is the KDF time (iterations) cost.
+	KDFTime uint32 `msgpack:"kdfTime,omitempty" db:"kdfTime"`
+	// KDFMemory is the KDF memory cost (in KiB).
+	KDFMemory uint32 `msgpack:"kdfMemory,omitempty" db:"kdfMemory"`
+	// KDFThreads is the KDF parallelism.
+	KDFThreads uint8 `msgpack:"kdfThreads,omitempty" db:"kdfThreads"`
+
+	// Name is a user-settable name or description.
+	Name string `msgpack:"name,omitempty" db:"name"`
+	// Product is the device product name (for FIDO2HMACSecretAuth).
+	Product string `msgpack:"product,omitempty" db:"product"`
+
 	CreatedAt time.Time `msgpack:"createdAt,omitempty" db:"createdAt"`
+	// LastUsedAt is when the auth was last used to unlock (in milliseconds),
+	// or 0 if never used.
+	LastUsedAt int64 `msgpack:"lastUsedAt,omitempty" db:"lastUsedAt"`
+	// Uses is the number of times the auth was used to unlock.
+	Uses int `msgpack:"uses,omitempty" db:"uses"`
+
+	// Meta is sealed metadata, if the auth was stored with sealed metadata (in
+	// which case the other metadata fields are empty in the auth db).
+	Meta []byte `msgpack:"meta,omitempty" db:"meta"`
 
 	// Deleted flag
 	Deleted bool `msgpack:"del,omitempty" db:"del"`
@@ -36,4 +82,9 @@ const (
 	PaperKeyType        Type = "paper-key"
 	PasswordType        Type = "password"
 	FIDO2HMACSecretType Type = "fido2-hmac-secret" // #nosec
+	X25519Type          Type = "x25519"
+	KeyFileType         Type = "key-file"
+	TokenType           Type = "token"
+	MultiFactorType     Type = "multi-factor"
+	ShamirType          Type = "shamir"
 )
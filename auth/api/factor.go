@@ -0,0 +1,58 @@
+package api
+
+import (
+	"database/sql/driver"
+
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// Factor describes a factor of multi-factor auth, with what's needed to
+// derive its key.
+type Factor struct {
+	Type Type `msgpack:"type"`
+
+	// Salt (for PasswordAuth, FIDO2HMACSecretAuth and KeyFileAuth)
+	Salt []byte `msgpack:"salt,omitempty"`
+
+	// CredentialID (for FIDO2HMACSecretAuth)
+	CredentialID []byte `msgpack:"cid,omitempty"`
+	// AAGUID (for FIDO2HMACSecretAuth)
+	AAGUID string `msgpack:"aaguid,omitempty"`
+	// NoPin (for FIDO2HMACSecretAuth)
+	NoPin bool `msgpack:"nopin,omitempty"`
+
+	// KDF parameters (for PasswordAuth)
+	KDF        string `msgpack:"kdf,omitempty"`
+	KDFTime    uint32 `msgpack:"kdfTime,omitempty"`
+	KDFMemory  uint32 `msgpack:"kdfMemory,omitempty"`
+	KDFThreads uint8  `msgpack:"kdfThreads,omitempty"`
+}
+
+// Factors for multi-factor auth.
+type Factors []*Factor
+
+// Scan for sql.Scanner.
+func (f *Factors) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*f = nil
+		return nil
+	case []byte:
+		if len(v) == 0 {
+			*f = nil
+			return nil
+		}
+		return msgpack.Unmarshal(v, f)
+	default:
+		return errors.Errorf("invalid factors")
+	}
+}
+
+// Value for driver.Valuer.
+func (f Factors) Value() (driver.Value, error) {
+	if len(f) == 0 {
+		return nil, nil
+	}
+	return msgpack.Marshal(f)
+}
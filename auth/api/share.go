@@ -0,0 +1,45 @@
+package api
+
+import (
+	"database/sql/driver"
+
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// Share describes a share of Shamir secret sharing (threshold) auth.
+// The share itself isn't stored.
+type Share struct {
+	// X is the share index (1-255).
+	X byte `msgpack:"x"`
+	// Hash verifies the share belongs to the auth.
+	Hash []byte `msgpack:"hash"`
+}
+
+// Shares for Shamir secret sharing (threshold) auth.
+type Shares []*Share
+
+// Scan for sql.Scanner.
+func (s *Shares) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case []byte:
+		if len(v) == 0 {
+			*s = nil
+			return nil
+		}
+		return msgpack.Unmarshal(v, s)
+	default:
+		return errors.Errorf("invalid shares")
+	}
+}
+
+// Value for driver.Valuer.
+func (s Shares) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	return msgpack.Marshal(s)
+}
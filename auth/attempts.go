@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/encoding"
+	"github.com/keys-pub/keys/tsutil"
+	"github.com/pkg/errors"
+)
+
+// Backoff defaults.
+const (
+	// DefaultFreeAttempts is the number of failed attempts allowed before
+	// delays are enforced.
+	DefaultFreeAttempts = 3
+	// DefaultBackoff is the delay after the first failed attempt past the free
+	// attempts, doubled for each subsequent failure.
+	DefaultBackoff = time.Second
+	// DefaultMaxBackoff is the maximum delay between attempts.
+	DefaultMaxBackoff = 15 * time.Minute
+)
+
+// Attempts is the failed unlock attempt state for an auth type.
+type Attempts struct {
+	Type Type `db:"-"`
+	// id is the attempts table key (see attemptsID).
+	id string
+	// Failures is the number of failed attempts since the last success.
+	Failures     int   `db:"failures"`
+	LastFailedAt int64 `db:"lastFailedAt"`
+	// LockedOut if the auth type is locked out (see WithLockout), until an
+	// unlock with a different auth type succeeds.
+	LockedOut bool `db:"lockedOut"`
+	// RetryAt is when the next attempt is allowed (0 if now).
+	RetryAt int64 `db:"-"`
+}
+
+// ErrTooManyAttempts if an unlock is attempted before the backoff delay has
+// passed, or the auth type is locked out.
+type ErrTooManyAttempts struct {
+	Type      Type
+	RetryAt   time.Time
+	LockedOut bool
+}
+
+func (e ErrTooManyAttempts) Error() string {
+	if e.LockedOut {
+		return fmt.Sprintf("too many attempts, %s is locked out", e.Type)
+	}
+	return fmt.Sprintf("too many attempts, retry at %s", e.RetryAt.Format(time.RFC3339))
+}
+
+// SetClock sets the clock (for testing).
+func (d *DB) SetClock(clock tsutil.Clock) {
+	d.clock = clock
+}
+
+// Attempts returns the failed unlock attempt state for an auth type.
+func (d *DB) Attempts(typ Type) (*Attempts, error) {
+	attempts, err := d.getAttempts(typ)
+	if err != nil {
+		return nil, err
+	}
+	attempts.RetryAt = d.retryAt(attempts)
+	return attempts, nil
+}
+
+// resetAttempts clears failed attempts (and lockouts) for all auth types.
+func (d *DB) resetAttempts() error {
+	if _, err := d.db.Exec("DELETE FROM attempts"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// attempt checks whether an unlock with auth type is allowed, runs fn, and
+// records the result.
+// A failure (ErrInvalidAuth) increments the failures for the auth type.
+// A success verifies the auth (see Verify), and unless verifying, clears the
+// failures (and lockouts) for all auth types, and updates the auth usage.
+// A duress password (see RegisterDuressPassword) isn't verified, and its
+// usage isn't updated.
+// If multi-factor auth is required (see SetMultiFactorRequired), other auth
+// types fail (with ErrInvalidAuth), before fn, so a valid auth isn't revealed.
+// If verifying (the Verify... methods, such as VerifyPassword, to re-confirm
+// an auth method while already unlocked), a duress password (see
+// RegisterDuressPassword) returns the decoy master key, but doesn't lock the
+// auth db or remove other auth methods.
+// Attempts are serialized, so concurrent attempts are each checked against
+// the failures recorded by the previous ones.
+func (d *DB) attempt(typ Type, verifying bool, fn func() (*Auth, *[32]byte, error)) (*Auth, *[32]byte, error) {
+	d.attemptMtx.Lock()
+	defer d.attemptMtx.Unlock()
+
+	attempts, err := d.getAttempts(typ)
+	if err != nil {
+		return nil, nil, err
+	}
+	if attempts.LockedOut {
+		return nil, nil, ErrTooManyAttempts{Type: typ, LockedOut: true}
+	}
+	if retryAt := d.retryAt(attempts); retryAt > d.clock.NowMillis() {
+		return nil, nil, ErrTooManyAttempts{Type: typ, RetryAt: tsutil.ParseMillis(retryAt)}
+	}
+	fail := func(err error) (*Auth, *[32]byte, error) {
+		if errors.Is(err, ErrInvalidAuth) {
+			attempts.Failures++
+			attempts.LastFailedAt = d.clock.NowMillis()
+			attempts.LockedOut = d.lockout > 0 && attempts.Failures >= d.lockout
+			if attempts.LockedOut {
+				logger.Warningf("Auth %s locked out after %d failed attempts", typ, attempts.Failures)
+			}
+			if err := setAttempts(d.db, attempts); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, nil, err
+	}
+
+	if typ != api.MultiFactorType {
+		required, err := multiFactorRequiredHint(d.db)
+		if err != nil {
+			return nil, nil, err
+		}
+		if required {
+			return fail(ErrInvalidAuth)
+		}
+	}
+	auth, mk, err := fn()
+	if err != nil {
+		return fail(err)
+	}
+	if isDuress(auth, mk) {
+		auth, err := d.duress(auth, mk, verifying)
+		if err != nil {
+			return nil, nil, err
+		}
+		return auth, mk, nil
+	}
+	if typ != api.MultiFactorType {
+		// In case the (unsealed) policy hint was removed. If there's no index
+		// (or it's invalid), it's handled by open.
+		_, idx, err := findIndex(d.db, indexKey(mk))
+		if err != nil && !errors.Is(err, ErrIndexInvalid) {
+			return nil, nil, err
+		}
+		if idx != nil && idx.MultiFactorRequired {
+			logger.Warningf("Multi-factor auth is required, but the policy hint is missing")
+			return fail(ErrInvalidAuth)
+		}
+	}
+	if err := d.open(mk, auth); err != nil {
+		return nil, nil, err
+	}
+	auth, _, err = unsealAuth(auth, d.metaKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !verifying {
+		if err := d.resetAttempts(); err != nil {
+			return nil, nil, err
+		}
+		if err := d.used(auth); err != nil {
+			return nil, nil, err
+		}
+	}
+	return auth, mk, nil
+}
+
+// retryAt returns when the next attempt is allowed (0 if no delay).
+func (d *DB) retryAt(attempts *Attempts) int64 {
+	n := attempts.Failures - d.freeAttempts
+	if attempts.Failures == 0 || n < 0 || d.backoff <= 0 {
+		return 0
+	}
+	delay := d.backoff
+	for i := 0; i < n && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.maxBackoff {
+		delay = d.maxBackoff
+	}
+	return attempts.LastFailedAt + int64(delay/time.Millisecond)
+}
+
+// attemptsID is the attempts table key for an auth type, a MAC of the type
+// with a random key (in config), so the auth types attempted aren't listed in
+// the auth db.
+// This is only obfuscation: the key is in the same db, and there are only a
+// few auth types, so anyone with the db can compute the ID for each type. (The
+// key can't be derived from the master key, since attempts are checked before
+// an unlock.)
+func (d *DB) attemptsID(typ Type) string {
+	h := hmac.New(sha256.New, d.attemptsKey)
+	_, _ = h.Write([]byte(typ))
+	return encoding.MustEncode(h.Sum(nil), encoding.Base62)
+}
+
+func (d *DB) getAttempts(typ Type) (*Attempts, error) {
+	id := d.attemptsID(typ)
+	var attempts Attempts
+	row := d.db.QueryRowx("SELECT failures, lastFailedAt, lockedOut FROM attempts WHERE type = $1", id)
+	if err := row.Scan(&attempts.Failures, &attempts.LastFailedAt, &attempts.LockedOut); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &Attempts{Type: typ, id: id}, nil
+		}
+		return nil, err
+	}
+	attempts.Type = typ
+	attempts.id = id
+	return &attempts, nil
+}
+
+func setAttempts(db *sqlx.DB, attempts *Attempts) error {
+	sql := `INSERT OR REPLACE INTO attempts (type, failures, lastFailedAt, lockedOut)
+			VALUES ($1, $2, $3, $4)`
+	if _, err := db.Exec(sql, attempts.id, attempts.Failures, attempts.LastFailedAt, attempts.LockedOut); err != nil {
+		return err
+	}
+	return nil
+}
+
+func initAttemptsKey(db *sqlx.DB) ([]byte, error) {
+	key, err := getConfigBytes(db, "attemptsKey")
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		return key, nil
+	}
+	key = keys.RandBytes(32)
+	if err := setConfigBytes(db, "attemptsKey", key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
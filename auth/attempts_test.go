@@ -0,0 +1,196 @@
+package auth_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/tsutil"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttempts(t *testing.T) {
+	path := testutil.Path()
+	defer func() { _ = os.Remove(path) }()
+	clock := tsutil.NewTestClock()
+
+	db, err := auth.NewDB(path, auth.WithLockout(6))
+	require.NoError(t, err)
+	db.SetClock(clock)
+
+	mk := testutil.Seed(0x01)
+	paperKey := keys.RandPhrase()
+	_, err = db.RegisterPaperKey(paperKey, mk)
+	require.NoError(t, err)
+	_, err = db.RegisterPassword("testpassword", mk)
+	require.NoError(t, err)
+
+	// Free attempts
+	for i := 0; i < auth.DefaultFreeAttempts; i++ {
+		_, _, err = db.PaperKey(keys.RandPhrase())
+		require.EqualError(t, err, "invalid auth")
+	}
+	attempts, err := db.Attempts(api.PaperKeyType)
+	require.NoError(t, err)
+	require.Equal(t, 3, attempts.Failures)
+	require.False(t, attempts.LockedOut)
+	require.Equal(t, attempts.LastFailedAt+1000, attempts.RetryAt)
+
+	// Backoff, even with the right paper key
+	_, _, err = db.PaperKey(paperKey)
+	var tooMany auth.ErrTooManyAttempts
+	require.True(t, errors.As(err, &tooMany))
+	require.False(t, tooMany.LockedOut)
+	require.Equal(t, tsutil.ParseMillis(attempts.RetryAt), tooMany.RetryAt)
+	attempts, err = db.Attempts(api.PaperKeyType)
+	require.NoError(t, err)
+	require.Equal(t, 3, attempts.Failures)
+
+	// Other auth types aren't affected
+	attempts, err = db.Attempts(api.PasswordType)
+	require.NoError(t, err)
+	require.Equal(t, 0, attempts.Failures)
+	require.Equal(t, int64(0), attempts.RetryAt)
+
+	clock.Add(time.Second)
+	_, _, err = db.PaperKey(keys.RandPhrase())
+	require.EqualError(t, err, "invalid auth")
+
+	// Persists across restart
+	require.NoError(t, db.Close())
+	db, err = auth.NewDB(path, auth.WithLockout(6))
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetClock(clock)
+
+	attempts, err = db.Attempts(api.PaperKeyType)
+	require.NoError(t, err)
+	require.Equal(t, 4, attempts.Failures)
+	require.Equal(t, attempts.LastFailedAt+2000, attempts.RetryAt)
+	_, _, err = db.PaperKey(paperKey)
+	require.True(t, errors.As(err, &tooMany))
+
+	clock.Add(2 * time.Second)
+	_, _, err = db.PaperKey(keys.RandPhrase())
+	require.EqualError(t, err, "invalid auth")
+	clock.Add(4 * time.Second)
+	_, _, err = db.PaperKey(keys.RandPhrase())
+	require.EqualError(t, err, "invalid auth")
+
+	// Locked out
+	attempts, err = db.Attempts(api.PaperKeyType)
+	require.NoError(t, err)
+	require.Equal(t, 6, attempts.Failures)
+	require.True(t, attempts.LockedOut)
+	clock.Add(auth.DefaultMaxBackoff)
+	_, _, err = db.PaperKey(paperKey)
+	require.EqualError(t, err, "too many attempts, paper-key is locked out")
+
+	// Cleared by another auth type
+	_, mko, err := db.Password("testpassword")
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	attempts, err = db.Attempts(api.PaperKeyType)
+	require.NoError(t, err)
+	require.Equal(t, 0, attempts.Failures)
+	require.False(t, attempts.LockedOut)
+
+	_, mko, err = db.PaperKey(paperKey)
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+}
+
+func TestAttemptsNoBackoff(t *testing.T) {
+	path := testutil.Path()
+	defer func() { _ = os.Remove(path) }()
+
+	db, err := auth.NewDB(path, auth.WithBackoff(0, 0, 0))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mk := testutil.Seed(0x01)
+	paperKey := keys.RandPhrase()
+	_, err = db.RegisterPaperKey(paperKey, mk)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		_, _, err = db.PaperKey(keys.RandPhrase())
+		require.EqualError(t, err, "invalid auth")
+	}
+	_, _, err = db.PaperKey(paperKey)
+	require.NoError(t, err)
+}
+
+func TestAttemptsVerify(t *testing.T) {
+	path := testutil.Path()
+	defer func() { _ = os.Remove(path) }()
+
+	db, err := auth.NewDB(path, auth.WithBackoff(0, 0, 0))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mk := testutil.Seed(0x01)
+	dmk := testutil.Seed(0x02)
+	_, err = db.RegisterPassword("testpassword", mk)
+	require.NoError(t, err)
+	_, err = db.RegisterDuressPassword("duresspassword", mk, dmk, false)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, _, err = db.Password("invalidpassword")
+		require.EqualError(t, err, "invalid auth")
+	}
+
+	// Verifying (even the duress password) doesn't reset attempts
+	_, _, err = db.VerifyPassword("testpassword")
+	require.NoError(t, err)
+	_, _, err = db.VerifyPassword("duresspassword")
+	require.NoError(t, err)
+	attempts, err := db.Attempts(api.PasswordType)
+	require.NoError(t, err)
+	require.Equal(t, 3, attempts.Failures)
+
+	_, _, err = db.Password("testpassword")
+	require.NoError(t, err)
+	attempts, err = db.Attempts(api.PasswordType)
+	require.NoError(t, err)
+	require.Equal(t, 0, attempts.Failures)
+}
+
+func TestAttemptsConcurrent(t *testing.T) {
+	path := testutil.Path()
+	defer func() { _ = os.Remove(path) }()
+
+	db, err := auth.NewDB(path, auth.WithLockout(3), auth.WithBackoff(10, time.Second, time.Minute))
+	require.NoError(t, err)
+
+	mk := testutil.Seed(0x01)
+	_, err = db.RegisterPaperKey(keys.RandPhrase(), mk)
+	require.NoError(t, err)
+
+	// Concurrent attempts can't exceed the lockout
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			_, _, err := db.PaperKey(keys.RandPhrase())
+			errs <- err
+		}()
+	}
+	invalid := 0
+	for i := 0; i < 10; i++ {
+		err := <-errs
+		if err == auth.ErrInvalidAuth {
+			invalid++
+			continue
+		}
+		var tooMany auth.ErrTooManyAttempts
+		require.True(t, errors.As(err, &tooMany))
+		require.True(t, tooMany.LockedOut)
+	}
+	require.Equal(t, 3, invalid)
+}
@@ -1 +1,68 @@
 package auth_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/tsutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenameAndUsage(t *testing.T) {
+	path := testutil.Path()
+	defer func() { _ = os.Remove(path) }()
+	clock := tsutil.NewTestClock()
+
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetClock(clock)
+
+	mk := testutil.Seed(0x01)
+	paperKey := keys.RandPhrase()
+	reg, err := db.RegisterPaperKey(paperKey, mk)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), reg.LastUsedAt)
+	require.Equal(t, 0, reg.Uses)
+
+	err = db.Rename(reg.ID, "Backup paper key")
+	require.NoError(t, err)
+	err = db.Rename("notfound", "test")
+	require.EqualError(t, err, "notfound not found")
+
+	out, _, err := db.PaperKey(paperKey)
+	require.NoError(t, err)
+	require.Equal(t, "Backup paper key", out.Name)
+	require.Equal(t, int64(1234567890002), out.LastUsedAt)
+	require.Equal(t, 1, out.Uses)
+
+	_, _, err = db.PaperKey(keys.RandPhrase())
+	require.EqualError(t, err, "invalid auth")
+	_, _, err = db.PaperKey(paperKey)
+	require.NoError(t, err)
+
+	auths, err := db.List()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(auths))
+	require.Equal(t, "Backup paper key", auths[0].Name)
+	require.Equal(t, int64(1234567890006), auths[0].LastUsedAt)
+	require.Equal(t, 2, auths[0].Uses)
+
+	// Verifying doesn't update usage
+	_, _, err = db.VerifyPaperKey(paperKey)
+	require.NoError(t, err)
+	auths, err = db.List()
+	require.NoError(t, err)
+	require.Equal(t, int64(1234567890006), auths[0].LastUsedAt)
+	require.Equal(t, 2, auths[0].Uses)
+
+	// Metadata is kept when auth is updated
+	require.NoError(t, db.Set(auths[0]))
+	auths, err = db.List()
+	require.NoError(t, err)
+	require.Equal(t, "Backup paper key", auths[0].Name)
+	require.Equal(t, 2, auths[0].Uses)
+}
@@ -2,11 +2,15 @@ package auth
 
 import (
 	"database/sql"
+	"runtime"
+	"sync"
+	"time"
 
 	"github.com/getchill-app/keyring/auth/api"
 	"github.com/jmoiron/sqlx"
 	"github.com/keys-pub/keys"
 	kapi "github.com/keys-pub/keys/api"
+	"github.com/keys-pub/keys/tsutil"
 	"github.com/pkg/errors"
 
 	// For sqlite3 (we use sqlcipher driver because it would conflict with vault
@@ -24,14 +28,55 @@ type Type = api.Type
 type DB struct {
 	db *sqlx.DB
 	ck *kapi.Key
+
+	minPasswordStrength PasswordStrength
+	kdf                 KDFParams
+	passwordWorkers     int
+
+	freeAttempts int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	lockout      int
+	clock        tsutil.Clock
+	// attemptMtx is held for an attempt, from checking the failures to
+	// recording the result, so concurrent attempts can't exceed the limits.
+	attemptMtx sync.Mutex
+	// attemptsKey keys the attempts table (see attemptsID).
+	attemptsKey []byte
+
+	sealed bool
+	decoys int
+
+	// indexKey and metaKey are derived from the master key on unlock or
+	// register (see Verify and WithSealedMetadata).
+	indexKey *[32]byte
+	metaKey  *[32]byte
+	// indexSlot is the slot of the index (see indexSlots), and decoy is
+	// whether it's for a decoy master key (see RegisterDuressPassword).
+	indexSlot string
+	decoy     bool
+}
+
+// DefaultPasswordWorkers is the default maximum number of concurrent password
+// key derivations.
+var DefaultPasswordWorkers = defaultPasswordWorkers()
+
+func defaultPasswordWorkers() int {
+	n := runtime.NumCPU()
+	if n > 4 {
+		return 4
+	}
+	return n
 }
 
 // NewDB creates an DB for auth.
 // This DB is unencrypted but the auth keys themselves are encrypted.
+// Deleted content is overwritten (secure_delete), so removed auth methods
+// don't remain in the file.
 func NewDB(path string, opt ...Option) (*DB, error) {
 	opts := newOptions(opt...)
 
-	db, err := sqlx.Open("sqlite3", path)
+	db, err := sqlx.Open("sqlite3", path+"?_secure_delete=on")
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to open db")
 	}
@@ -42,11 +87,46 @@ func NewDB(path string, opt ...Option) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &DB{db: db, ck: ck}, nil
+	attemptsKey, err := initAttemptsKey(db)
+	if err != nil {
+		return nil, err
+	}
+	kdf := DefaultKDFParams
+	if opts.KDFParams != nil {
+		kdf = *opts.KDFParams
+	}
+	workers := DefaultPasswordWorkers
+	if opts.PasswordWorkers > 0 {
+		workers = opts.PasswordWorkers
+	}
+	backoff := Backoff{Free: DefaultFreeAttempts, Delay: DefaultBackoff, Max: DefaultMaxBackoff}
+	if opts.Backoff != nil {
+		backoff = *opts.Backoff
+	}
+	return &DB{
+		db:                  db,
+		ck:                  ck,
+		minPasswordStrength: opts.MinPasswordStrength,
+		kdf:                 kdf,
+		passwordWorkers:     workers,
+		freeAttempts:        backoff.Free,
+		backoff:             backoff.Delay,
+		maxBackoff:          backoff.Max,
+		lockout:             opts.Lockout,
+		clock:               tsutil.NewClock(),
+		attemptsKey:         attemptsKey,
+		sealed:              opts.SealedMetadata,
+		decoys:              opts.Decoys,
+	}, nil
 }
 
 func (d *DB) unlock(auth *Auth, key *[32]byte) *[32]byte {
-	k, ok := secretBoxOpen(auth.EncryptedKey, key)
+	ek := auth.EncryptedKey
+	if len(ek) > secretBoxKeySize {
+		// Padded (see sealAuth)
+		ek = ek[:secretBoxKeySize]
+	}
+	k, ok := secretBoxOpen(ek, key)
 	if !ok {
 		logger.Debugf("Failed %s", auth.ID)
 		return nil
@@ -73,12 +153,65 @@ func initTables(db *sqlx.DB) error {
 			key TEXT PRIMARY KEY NOT NULL,
 			value TEXT NOT NULL
 		);`,
+		`CREATE TABLE IF NOT EXISTS attempts (
+			type TEXT NOT NULL PRIMARY KEY,
+			failures INTEGER NOT NULL,
+			lastFailedAt INTEGER NOT NULL,
+			lockedOut BOOL NOT NULL
+		);`,
 	}
 	for _, stmt := range stmts {
 		if _, err := db.Exec(stmt); err != nil {
 			return err
 		}
 	}
+	// Columns added after the auth table was created.
+	if err := addColumns(db, "auth", []column{
+		{"kdf", "TEXT NOT NULL DEFAULT ''"},
+		{"kdfTime", "INTEGER NOT NULL DEFAULT 0"},
+		{"kdfMemory", "INTEGER NOT NULL DEFAULT 0"},
+		{"kdfThreads", "INTEGER NOT NULL DEFAULT 0"},
+		{"name", "TEXT NOT NULL DEFAULT ''"},
+		{"product", "TEXT NOT NULL DEFAULT ''"},
+		{"lastUsedAt", "INTEGER NOT NULL DEFAULT 0"},
+		{"uses", "INTEGER NOT NULL DEFAULT 0"},
+		{"meta", "BLOB"},
+		{"kid", "TEXT NOT NULL DEFAULT ''"},
+		{"fingerprint", "BLOB"},
+		{"expiresAt", "INTEGER NOT NULL DEFAULT 0"},
+		{"factors", "BLOB"},
+		{"threshold", "INTEGER NOT NULL DEFAULT 0"},
+		{"shares", "BLOB"},
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+type column struct {
+	name string
+	def  string
+}
+
+// addColumns adds columns to a table if they don't exist.
+func addColumns(db *sqlx.DB, table string, cols []column) error {
+	var existing []string
+	if err := db.Select(&existing, "SELECT name FROM pragma_table_info($1)", table); err != nil {
+		return err
+	}
+	has := map[string]bool{}
+	for _, name := range existing {
+		has[name] = true
+	}
+	for _, col := range cols {
+		if has[col.name] {
+			continue
+		}
+		logger.Debugf("Adding column %s.%s", table, col.name)
+		if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + col.name + " " + col.def); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -87,28 +220,82 @@ func (d *DB) Close() error {
 }
 
 // Set adds or updates auth method.
+// The auth index is updated if we have the master key (see Verify).
+// If WithSealedMetadata, requires the master key, otherwise returns
+// ErrLocked.
+// If multi-factor auth is required (see SetMultiFactorRequired), other auth
+// types return ErrMultiFactorRequired.
 func (d *DB) Set(auth *Auth) error {
 	return Transact(d.db, func(tx *sqlx.Tx) error {
-		if err := setTx(tx, auth); err != nil {
+		if err := d.checkMultiFactorTx(tx, auth); err != nil {
 			return err
 		}
-		return nil
+		if err := d.setAuthTx(tx, auth); err != nil {
+			return err
+		}
+		return d.padTx(tx)
 	})
 }
 
 // Delete auth method.
+// The auth index is updated if we have the master key (see Verify).
 func (d *DB) Delete(id string) error {
 	return Transact(d.db, func(tx *sqlx.Tx) error {
-		if err := deleteTx(tx, id); err != nil {
+		if err := d.deleteAuthTx(tx, id); err != nil {
+			return err
+		}
+		return d.padTx(tx)
+	})
+}
+
+func (d *DB) setAuthTx(tx *sqlx.Tx, auth *Auth) error {
+	row, err := d.storedAuth(auth)
+	if err != nil {
+		return err
+	}
+	return d.setRowTx(tx, row)
+}
+
+func (d *DB) setRowTx(tx *sqlx.Tx, row *Auth) error {
+	if err := setTx(tx, row); err != nil {
+		return err
+	}
+	return d.updateIndexTx(tx, func(digests map[string][]byte) error {
+		digest, err := authDigest(row)
+		if err != nil {
 			return err
 		}
+		digests[row.ID] = digest
 		return nil
 	})
 }
 
+func (d *DB) deleteAuthTx(tx *sqlx.Tx, id string) error {
+	if err := deleteTx(tx, id); err != nil {
+		return err
+	}
+	if err := d.updateIndexTx(tx, func(digests map[string][]byte) error {
+		delete(digests, id)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if d.indexKey == nil || d.decoy {
+		return nil
+	}
+	idx, err := getIndexTx(tx, d.indexSlot, d.indexKey)
+	if err != nil {
+		return err
+	}
+	if idx != nil && idx.Decoy != nil && idx.Decoy.DuressID == id {
+		return d.removeDecoyTx(tx, idx)
+	}
+	return nil
+}
+
 func setTx(tx *sqlx.Tx, auth *Auth) error {
-	sql := `INSERT OR REPLACE INTO auth (id, ek, type, createdAt, salt, aaguid, nopin) 
-			VALUES (:id, :ek, :type, :createdAt, :salt, :aaguid, :nopin)`
+	sql := `INSERT OR REPLACE INTO auth (id, ek, type, createdAt, salt, aaguid, nopin, kdf, kdfTime, kdfMemory, kdfThreads, name, product, lastUsedAt, uses, meta, kid, fingerprint, expiresAt, factors, threshold, shares) 
+			VALUES (:id, :ek, :type, :createdAt, :salt, :aaguid, :nopin, :kdf, :kdfTime, :kdfMemory, :kdfThreads, :name, :product, :lastUsedAt, :uses, :meta, :kid, :fingerprint, :expiresAt, :factors, :threshold, :shares)`
 	if _, err := tx.NamedExec(sql, auth); err != nil {
 		return err
 	}
@@ -122,8 +309,99 @@ func deleteTx(tx *sqlx.Tx, id string) error {
 	return nil
 }
 
+// Rename sets the name (or description) for an auth method.
+// If WithSealedMetadata, requires the master key, otherwise returns ErrLocked.
+// If not found, returns keys.ErrNotFound.
+func (d *DB) Rename(id string, name string) error {
+	if d.sealed {
+		auth, err := d.get(id)
+		if err != nil {
+			return err
+		}
+		if auth == nil {
+			return keys.NewErrNotFound(id)
+		}
+		auth.Name = name
+		return d.Set(auth)
+	}
+	res, err := d.db.Exec("UPDATE auth SET name = $1 WHERE id = $2", name, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return keys.NewErrNotFound(id)
+	}
+	return nil
+}
+
+// used updates the last used time and use count for an auth method.
+func (d *DB) used(auth *Auth) error {
+	auth.LastUsedAt = d.clock.NowMillis()
+	auth.Uses++
+	if d.sealed {
+		return d.Set(auth)
+	}
+	if _, err := d.db.Exec("UPDATE auth SET lastUsedAt = $1, uses = uses + 1 WHERE id = $2", auth.LastUsedAt, auth.ID); err != nil {
+		return err
+	}
+	return nil
+}
+
 // List auth.
+// If we have the master key (from an unlock or register), auth methods are
+// verified, and if any were changed outside of this package, returns
+// ErrTampered (see Verify).
 func (d *DB) List() ([]*Auth, error) {
+	auths, err := d.list()
+	if err != nil {
+		return nil, err
+	}
+	if d.indexKey != nil {
+		idx, err := getIndex(d.db, d.indexSlot, d.indexKey)
+		if err != nil {
+			return nil, err
+		}
+		if idx == nil {
+			return nil, errIndexMissing()
+		}
+		v, err := d.checkIndex(auths, idx, d.indexSlot, d.decoy)
+		if err != nil {
+			return nil, err
+		}
+		if !v.OK() {
+			return nil, ErrTampered{v}
+		}
+	}
+	if d.metaKey != nil {
+		return d.unsealAuths(auths)
+	}
+	return auths, nil
+}
+
+// get returns auth (with sealed metadata restored), or nil if not found.
+func (d *DB) get(id string) (*Auth, error) {
+	var row Auth
+	if err := d.db.Get(&row, "SELECT * FROM auth WHERE id = $1", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	auth, decoy, err := unsealAuth(&row, d.metaKey)
+	if err != nil {
+		return nil, err
+	}
+	if decoy {
+		return nil, nil
+	}
+	return auth, nil
+}
+
+func (d *DB) list() ([]*Auth, error) {
 	var auths []*Auth
 	if err := d.db.Select(&auths, "SELECT * FROM auth"); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -138,18 +416,41 @@ func (d *DB) List() ([]*Auth, error) {
 }
 
 // ListByType lists auth by type.
+// Auth with sealed metadata (see WithSealedMetadata) have no type, so without
+// the master key (or sealed with another one), they are included for password
+// and paper key types.
 func (d *DB) ListByType(typ Type) ([]*Auth, error) {
-	var auths []*Auth
-	if err := d.db.Select(&auths, "SELECT * FROM auth WHERE type = $1", typ); err != nil {
+	query := "SELECT * FROM auth WHERE type = $1"
+	if typ != api.FIDO2HMACSecretType {
+		query += " OR (type = '' AND meta IS NOT NULL)"
+	}
+	var rows []*Auth
+	if err := d.db.Select(&rows, query, typ); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	if len(auths) == 0 {
-		return []*Auth{}, nil
+	if d.metaKey == nil {
+		if len(rows) == 0 {
+			return []*Auth{}, nil
+		}
+		return rows, nil
 	}
-	return auths, nil
+	out := []*Auth{}
+	for _, row := range rows {
+		auth, decoy, err := unsealAuth(row, d.metaKey)
+		if err != nil {
+			// Sealed with another master key (see RegisterDuressPassword), so
+			// as if locked.
+			out = append(out, row)
+			continue
+		}
+		if !decoy && auth.Type == typ {
+			out = append(out, auth)
+		}
+	}
+	return out, nil
 }
 
 // Transact creates and executes a transaction.
@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"crypto/subtle"
+
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/encoding"
+	"github.com/pkg/errors"
+)
+
+// ErrDuressRegistered if registering a duress password when there is already
+// one (see RegisterDuressPassword).
+var ErrDuressRegistered = errors.New("duress password already registered")
+
+// RegisterDuressPassword registers a duress password, which opens a decoy
+// master key (dmk) instead of the master key.
+// The duress password looks like any other password auth; it's marked by its
+// salt, which can only be checked with the decoy master key.
+// The decoy master key has its own auth index (the decoy index), created from
+// the current auth methods, so after a duress unlock, auth methods can be
+// registered (and verified) with it as usual, without changing (or reporting
+// changes to) the auth index for the master key.
+// Unlocking with a duress password doesn't update usage. If destroy, it also
+// removes all other auth methods.
+// There can be one duress password, so if there is one, returns
+// ErrDuressRegistered; to replace it, delete it first, which also removes the
+// decoy index and the auth methods registered with it.
+// With a decoy master key (after a duress unlock), a duress password is
+// registered (so it looks the same), but without a decoy index.
+// Requires the master key, so the duress password is in the auth index (see
+// Verify).
+func (d *DB) RegisterDuressPassword(password string, mk *[32]byte, dmk *[32]byte, destroy bool) (*Auth, error) {
+	if mk == nil || dmk == nil {
+		return nil, errors.Errorf("nil master key")
+	}
+	if *mk == *dmk {
+		return nil, errors.Errorf("decoy master key is the master key")
+	}
+	if err := d.checkPasswordStrength(password); err != nil {
+		return nil, err
+	}
+	auth, err := newPassword(password, dmk, d.kdf, duressSalt(dmk, destroy, d.passwordSaltSize()))
+	if err != nil {
+		return nil, err
+	}
+	if err := d.open(mk, nil); err != nil {
+		return nil, err
+	}
+
+	if err := Transact(d.db, func(tx *sqlx.Tx) error {
+		if err := d.checkDuressTx(tx); err != nil {
+			return err
+		}
+		if err := d.checkMultiFactorTx(tx, auth); err != nil {
+			return err
+		}
+		if err := d.setAuthTx(tx, auth); err != nil {
+			return err
+		}
+		if err := d.padTx(tx); err != nil {
+			return err
+		}
+		if d.decoy {
+			// There is only one decoy index.
+			return nil
+		}
+		return d.setDecoyIndexTx(tx, auth, dmk)
+	}); err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+// checkDuressTx returns ErrDuressRegistered if there is a duress password.
+// If its auth method was removed (without the master key), the decoy index is
+// removed.
+func (d *DB) checkDuressTx(tx *sqlx.Tx) error {
+	if d.decoy {
+		return nil
+	}
+	idx, err := getIndexTx(tx, d.indexSlot, d.indexKey)
+	if err != nil {
+		return err
+	}
+	if idx == nil || idx.Decoy == nil {
+		return nil
+	}
+	var n int
+	if err := tx.Get(&n, "SELECT COUNT(*) FROM auth WHERE id = $1", idx.Decoy.DuressID); err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrDuressRegistered
+	}
+	return d.removeDecoyTx(tx, idx)
+}
+
+// removeDecoyTx removes the decoy index, and auth methods registered with the
+// decoy master key (only in the decoy index), when the duress password is
+// removed.
+func (d *DB) removeDecoyTx(tx *sqlx.Tx, idx *authIndex) error {
+	slot := otherSlot(d.indexSlot)
+	decoy, err := getIndexTx(tx, slot, keys.Bytes32(idx.Decoy.Key))
+	if err != nil {
+		logger.Warningf("Failed to open decoy index: %v", err)
+	}
+	if decoy != nil {
+		for id := range decoy.Digests {
+			if _, ok := idx.Digests[id]; ok {
+				continue
+			}
+			if err := deleteTx(tx, id); err != nil {
+				return err
+			}
+		}
+	}
+	idx.Decoy = nil
+	if err := setIndexTx(tx, d.indexSlot, d.indexKey, idx); err != nil {
+		return err
+	}
+	return fillSlotTx(tx, slot, true)
+}
+
+// setDecoyIndexTx creates the decoy index (in the other slot) from the current
+// auth methods, and references it from the auth index.
+func (d *DB) setDecoyIndexTx(tx *sqlx.Tx, auth *Auth, dmk *[32]byte) error {
+	idx, err := getIndexTx(tx, d.indexSlot, d.indexKey)
+	if err != nil {
+		return err
+	}
+	if idx == nil {
+		return errIndexMissing()
+	}
+	var rows []*Auth
+	if err := tx.Select(&rows, "SELECT * FROM auth"); err != nil {
+		return err
+	}
+	decoy, err := newIndex(encoding.MustEncode(keys.RandBytes(32), encoding.Base62), rows)
+	if err != nil {
+		return err
+	}
+	decoy.MultiFactorRequired = idx.MultiFactorRequired
+	key := indexKey(dmk)
+	if err := setIndexTx(tx, otherSlot(d.indexSlot), key, decoy); err != nil {
+		return err
+	}
+	idx.Decoy = &decoyIndex{Key: key[:], DuressID: auth.ID}
+	return setIndexTx(tx, d.indexSlot, d.indexKey, idx)
+}
+
+// duress is called (from attempt) for an unlock with a duress password.
+// The derived keys are replaced with those for the decoy master key (see
+// openDecoy), so nothing is updated with the master key, and if the duress
+// password was registered to destroy, all other auth methods are removed
+// (overwritten, see NewDB), along with the auth index for the master key.
+// If verifying (see attempt), the derived keys are kept, and nothing is
+// removed or reset.
+func (d *DB) duress(auth *Auth, dmk *[32]byte, verifying bool) (*Auth, error) {
+	if !verifying {
+		d.Lock()
+		if err := d.openDecoy(dmk); err != nil {
+			return nil, err
+		}
+		if duressDestroy(auth, dmk) {
+			if err := Transact(d.db, func(tx *sqlx.Tx) error {
+				if err := deleteOthersTx(tx, auth.ID); err != nil {
+					return err
+				}
+				if d.indexKey == nil {
+					return nil
+				}
+				if err := d.updateIndexTx(tx, func(digests map[string][]byte) error {
+					for id := range digests {
+						if id != auth.ID {
+							delete(digests, id)
+						}
+					}
+					return nil
+				}); err != nil {
+					return err
+				}
+				return fillSlotTx(tx, otherSlot(d.indexSlot), true)
+			}); err != nil {
+				return nil, err
+			}
+			// Rebuild the file, so removed auth methods don't remain in free
+			// pages.
+			if _, err := d.db.Exec("VACUUM"); err != nil {
+				return nil, err
+			}
+		}
+		if err := d.resetAttempts(); err != nil {
+			return nil, err
+		}
+	}
+	out := *auth
+	out.Type = api.PasswordType
+	out.Meta = nil
+	return &out, nil
+}
+
+func deleteOthersTx(tx *sqlx.Tx, id string) error {
+	if _, err := tx.Exec("DELETE FROM auth WHERE id != $1", id); err != nil {
+		return err
+	}
+	return nil
+}
+
+// duressSalt is a random salt with a tag (the last 16 bytes, derived from the
+// decoy master key) marking a duress password, and whether to destroy.
+// It's the size of other password salts (see passwordSaltSize).
+func duressSalt(dmk *[32]byte, destroy bool, size int) []byte {
+	r := keys.RandBytes(size - 16)
+	return append(r, duressTag(dmk, r, destroy)...)
+}
+
+func duressTag(dmk *[32]byte, r []byte, destroy bool) []byte {
+	info := "keyring/auth/duress"
+	if destroy {
+		info = "keyring/auth/duress/destroy"
+	}
+	return keys.HKDFSHA256(dmk[:], 16, r, []byte(info))
+}
+
+func isDuress(auth *Auth, mk *[32]byte) bool {
+	if auth.Type != api.PasswordType && auth.Type != api.UnknownType {
+		return false
+	}
+	if len(auth.Salt) < 24 || mk == nil {
+		return false
+	}
+	r, tag := auth.Salt[:len(auth.Salt)-16], auth.Salt[len(auth.Salt)-16:]
+	return subtle.ConstantTimeCompare(tag, duressTag(mk, r, false)) == 1 ||
+		duressDestroy(auth, mk)
+}
+
+func duressDestroy(auth *Auth, mk *[32]byte) bool {
+	if len(auth.Salt) < 24 || mk == nil {
+		return false
+	}
+	r, tag := auth.Salt[:len(auth.Salt)-16], auth.Salt[len(auth.Salt)-16:]
+	return subtle.ConstantTimeCompare(tag, duressTag(mk, r, true)) == 1
+}
@@ -0,0 +1,226 @@
+package auth_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuressPassword(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+
+	mk := testutil.Seed(0x01)
+	dmk := testutil.Seed(0x02)
+	_, err = db.RegisterPassword("testpassword", mk)
+	require.NoError(t, err)
+	_, err = db.RegisterPaperKey(keys.RandPhrase(), mk)
+	require.NoError(t, err)
+	_, err = db.RegisterDuressPassword("duresspassword", mk, mk, false)
+	require.EqualError(t, err, "decoy master key is the master key")
+	reg, err := db.RegisterDuressPassword("duresspassword", mk, dmk, false)
+	require.NoError(t, err)
+	require.Equal(t, api.PasswordType, reg.Type)
+
+	out, mko, err := db.Password("duresspassword")
+	require.NoError(t, err)
+	require.Equal(t, dmk, mko)
+	require.Equal(t, reg.ID, out.ID)
+	require.Equal(t, api.PasswordType, out.Type)
+	require.Equal(t, 0, out.Uses)
+
+	// The decoy master key has its own index
+	v, err := db.Verify()
+	require.NoError(t, err)
+	require.True(t, v.OK())
+	decoyPaperKey := keys.RandPhrase()
+	_, err = db.RegisterPaperKey(decoyPaperKey, dmk)
+	require.NoError(t, err)
+	err = db.SetMultiFactorRequired(false)
+	require.NoError(t, err)
+	auths, err := db.List()
+	require.NoError(t, err)
+	require.Equal(t, 4, len(auths))
+
+	// Which a paper key registered with it opens
+	_, mko, err = db.PaperKey(decoyPaperKey)
+	require.NoError(t, err)
+	require.Equal(t, dmk, mko)
+	v, err = db.Verify()
+	require.NoError(t, err)
+	require.True(t, v.OK())
+
+	// Changes with the decoy master key aren't reported for the master key
+	_, mko, err = db.Password("testpassword")
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	v, err = db.Verify()
+	require.NoError(t, err)
+	require.True(t, v.OK())
+	auths, err = db.List()
+	require.NoError(t, err)
+	require.Equal(t, 4, len(auths))
+
+	// Changes with the master key aren't reported for the decoy master key
+	_, err = db.RegisterPaperKey(keys.RandPhrase(), mk)
+	require.NoError(t, err)
+	_, _, err = db.Password("duresspassword")
+	require.NoError(t, err)
+	v, err = db.Verify()
+	require.NoError(t, err)
+	require.True(t, v.OK())
+}
+
+func TestDuressPasswordRegistered(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+
+	mk := testutil.Seed(0x01)
+	dmk := testutil.Seed(0x02)
+	_, err = db.RegisterPassword("testpassword", mk)
+	require.NoError(t, err)
+	reg, err := db.RegisterDuressPassword("duresspassword", mk, dmk, false)
+	require.NoError(t, err)
+	_, err = db.RegisterDuressPassword("duresspassword2", mk, testutil.Seed(0x03), false)
+	require.Equal(t, auth.ErrDuressRegistered, err)
+
+	// Auth methods registered with the decoy master key
+	_, _, err = db.Password("duresspassword")
+	require.NoError(t, err)
+	_, err = db.RegisterPaperKey(keys.RandPhrase(), dmk)
+	require.NoError(t, err)
+
+	// Deleting the duress password removes them too
+	_, _, err = db.Password("testpassword")
+	require.NoError(t, err)
+	auths, err := db.List()
+	require.NoError(t, err)
+	require.Equal(t, 3, len(auths))
+	err = db.Delete(reg.ID)
+	require.NoError(t, err)
+	auths, err = db.List()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(auths))
+	v, err := db.Verify()
+	require.NoError(t, err)
+	require.True(t, v.OK())
+
+	// And it can be replaced
+	_, err = db.RegisterDuressPassword("duresspassword2", mk, testutil.Seed(0x03), false)
+	require.NoError(t, err)
+	_, mko, err := db.Password("duresspassword2")
+	require.NoError(t, err)
+	require.Equal(t, testutil.Seed(0x03), mko)
+	_, _, err = db.Password("duresspassword")
+	require.Equal(t, auth.ErrInvalidAuth, err)
+}
+
+func TestDuressPasswordIndexSlots(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+
+	mk := testutil.Seed(0x01)
+	dmk := testutil.Seed(0x02)
+	_, err = db.RegisterPassword("testpassword", mk)
+	require.NoError(t, err)
+
+	sdb, err := sqlx.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer sdb.Close()
+	slots := func() []int {
+		var values []string
+		err := sdb.Select(&values, "SELECT value FROM config WHERE key IN ('authIndex', 'authIndex2')")
+		require.NoError(t, err)
+		sizes := []int{}
+		for _, value := range values {
+			sizes = append(sizes, len(value))
+		}
+		return sizes
+	}
+
+	// Both index slots are used (and the same size), with or without a
+	// duress password
+	before := slots()
+	require.Equal(t, 2, len(before))
+	require.Equal(t, before[0], before[1])
+	_, err = db.RegisterDuressPassword("duresspassword", mk, dmk, false)
+	require.NoError(t, err)
+	require.Equal(t, before, slots())
+}
+
+func TestDuressPasswordDestroy(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path, auth.WithSealedMetadata())
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+
+	mk := testutil.Seed(0x01)
+	dmk := testutil.Seed(0x02)
+	pw, err := db.RegisterPassword("testpassword", mk)
+	require.NoError(t, err)
+	reg, err := db.RegisterDuressPassword("duresspassword", mk, dmk, true)
+	require.NoError(t, err)
+	db.Lock()
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.True(t, bytes.Contains(b, pw.EncryptedKey))
+
+	out, mko, err := db.Password("duresspassword")
+	require.NoError(t, err)
+	require.Equal(t, dmk, mko)
+	require.Equal(t, reg.ID, out.ID)
+
+	_, _, err = db.Password("testpassword")
+	require.EqualError(t, err, "invalid auth")
+
+	// Removed auth methods don't remain in the file
+	b, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(b, pw.EncryptedKey))
+
+	// The duress password still works
+	_, mko, err = db.Password("duresspassword")
+	require.NoError(t, err)
+	require.Equal(t, dmk, mko)
+}
+
+func TestDuressPasswordVerify(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+
+	mk := testutil.Seed(0x01)
+	dmk := testutil.Seed(0x02)
+	_, err = db.RegisterPassword("testpassword", mk)
+	require.NoError(t, err)
+	reg, err := db.RegisterDuressPassword("duresspassword", mk, dmk, true)
+	require.NoError(t, err)
+
+	out, mko, err := db.VerifyPassword("duresspassword")
+	require.NoError(t, err)
+	require.Equal(t, dmk, mko)
+	require.Equal(t, reg.ID, out.ID)
+
+	// Not locked, and nothing removed
+	v, err := db.Verify()
+	require.NoError(t, err)
+	require.True(t, v.OK())
+	auths, err := db.List()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(auths))
+}
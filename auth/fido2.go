@@ -3,6 +3,7 @@ package auth
 import (
 	"bytes"
 	"context"
+	"strings"
 	"time"
 
 	"github.com/getchill-app/keyring/auth/api"
@@ -24,6 +25,8 @@ type FIDO2HMACSecret struct {
 	Salt         []byte
 	AAGUID       string
 	NoPin        bool
+	// Product is the device product name.
+	Product string
 }
 
 // GenerateFIDO2HMACSecret creates FIDO2 hmac-secret on a device.
@@ -78,6 +81,7 @@ func GenerateFIDO2HMACSecret(ctx context.Context, plugin fido2.FIDO2Server, pin
 		AAGUID:       dev.DeviceInfo.AAGUID,
 		Salt:         salt[:],
 		NoPin:        noPin,
+		Product:      dev.Device.Product,
 	}
 
 	return fhs, nil
@@ -95,6 +99,7 @@ func (d *DB) RegisterFIDO2HMACSecret(ctx context.Context, plugin fido2.FIDO2Serv
 		Salt:      hs.Salt,
 		AAGUID:    hs.AAGUID,
 		NoPin:     hs.NoPin,
+		Product:   hs.Product,
 		CreatedAt: time.Now(),
 	}
 
@@ -106,6 +111,9 @@ func (d *DB) RegisterFIDO2HMACSecret(ctx context.Context, plugin fido2.FIDO2Serv
 		return nil, errors.Errorf("invalid hmac-secret key length")
 	}
 	auth.EncryptedKey = secretBoxSeal(mk[:], key)
+	if err := d.open(mk, nil); err != nil {
+		return nil, err
+	}
 	if err := d.Set(auth); err != nil {
 		return nil, err
 	}
@@ -114,17 +122,55 @@ func (d *DB) RegisterFIDO2HMACSecret(ctx context.Context, plugin fido2.FIDO2Serv
 
 // FIDO2HMACSecret authenticates using FIDO2 hmac-secret.
 func (d *DB) FIDO2HMACSecret(ctx context.Context, plugin fido2.FIDO2Server, pin string) (*Auth, *[32]byte, error) {
+	auth, mk, _, err := d.FIDO2HMACSecretDevice(ctx, plugin, pin)
+	return auth, mk, err
+}
+
+// FIDO2HMACSecretDevice authenticates using FIDO2 hmac-secret, returning the
+// device used.
+// Devices are matched by credential ID, so with multiple devices of the same
+// model, the device with the credential is used (see findAuths).
+func (d *DB) FIDO2HMACSecretDevice(ctx context.Context, plugin fido2.FIDO2Server, pin string) (*Auth, *[32]byte, *fido2.Device, error) {
+	return d.fido2HMACSecretDevice(ctx, plugin, pin, false)
+}
+
+// VerifyFIDO2HMACSecret checks FIDO2 hmac-secret, like FIDO2HMACSecret, to
+// re-confirm it while already unlocked (see attempt).
+func (d *DB) VerifyFIDO2HMACSecret(ctx context.Context, plugin fido2.FIDO2Server, pin string) (*Auth, *[32]byte, error) {
+	auth, mk, _, err := d.fido2HMACSecretDevice(ctx, plugin, pin, true)
+	return auth, mk, err
+}
+
+func (d *DB) fido2HMACSecretDevice(ctx context.Context, plugin fido2.FIDO2Server, pin string, verifying bool) (*Auth, *[32]byte, *fido2.Device, error) {
+	var device *fido2.Device
+	auth, mk, err := d.attempt(api.FIDO2HMACSecretType, verifying, func() (*Auth, *[32]byte, error) {
+		ad, mk, err := d.fido2HMACSecret(ctx, plugin, pin)
+		if err != nil {
+			return nil, nil, err
+		}
+		device = ad.Device
+		return ad.Auth, mk, nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return auth, mk, device, nil
+}
+
+func (d *DB) fido2HMACSecret(ctx context.Context, plugin fido2.FIDO2Server, pin string) (*authDevice, *[32]byte, error) {
 	auths, err := d.ListByType(api.FIDO2HMACSecretType)
 	if err != nil {
 		return nil, nil, err
 	}
-	// TODO: How to choose if multiple device matches.
-	auth, key, err := hmacSecret(ctx, plugin, auths, pin)
+	var mk *[32]byte
+	ad, _, err := hmacSecretDevice(ctx, plugin, auths, pin, func(ad *authDevice, key *[32]byte) bool {
+		mk = d.unlock(ad.Auth, key)
+		return mk != nil
+	})
 	if err != nil {
 		return nil, nil, err
 	}
-	mk := d.unlock(auth, key)
-	return auth, mk, nil
+	return ad, mk, nil
 }
 
 func findDevice(ctx context.Context, plugin fido2.FIDO2Server, query string) (*authDevice, error) {
@@ -152,17 +198,26 @@ func findDevice(ctx context.Context, plugin fido2.FIDO2Server, query string) (*a
 	return nil, nil
 }
 
-func matchAAGUID(auths []*Auth, aaguid string) *Auth {
+// matchAAGUID returns auths matching aaguid.
+// Auth with sealed metadata (see WithSealedMetadata) have no AAGUID, and match
+// any device.
+func matchAAGUID(auths []*Auth, aaguid string) []*Auth {
+	matches := []*Auth{}
 	for _, auth := range auths {
-		if auth.AAGUID == aaguid {
-			return auth
+		if auth.AAGUID == aaguid || (auth.AAGUID == "" && len(auth.Meta) > 0) {
+			matches = append(matches, auth)
 		}
 	}
-	return nil
+	return matches
 }
 
-// findAuth returns a auth matching the auth credentials (aaguid).
-func findAuth(ctx context.Context, plugin fido2.FIDO2Server, auths []*Auth) (*authDevice, error) {
+// findAuths returns devices and auths to try, most likely first.
+// Devices of the same model share an AAGUID, so for each device (with the
+// hmac-secret extension), auths matching the AAGUID are probed with an
+// assertion (without user presence) for the credential ID. Devices with the
+// credential are returned first, then devices that couldn't be probed (matched
+// only by AAGUID).
+func findAuths(ctx context.Context, plugin fido2.FIDO2Server, auths []*Auth) ([]*authDevice, error) {
 	if auths == nil {
 		return nil, errors.Errorf("fido2 plugin not available")
 	}
@@ -178,6 +233,8 @@ func findAuth(ctx context.Context, plugin fido2.FIDO2Server, auths []*Auth) (*au
 		return nil, errors.Errorf("no devices found")
 	}
 
+	found := []*authDevice{}
+	unprobed := []*authDevice{}
 	for _, device := range devicesResp.Devices {
 		infoResp, err := plugin.DeviceInfo(ctx, &fido2.DeviceInfoRequest{Device: device.Path})
 		if err != nil {
@@ -187,38 +244,113 @@ func findAuth(ctx context.Context, plugin fido2.FIDO2Server, auths []*Auth) (*au
 		}
 		deviceInfo := infoResp.Info
 		logger.Debugf("Checking device: %v", deviceInfo)
-		if deviceInfo.HasExtension(fido2.HMACSecretExtension) {
-			auth := matchAAGUID(auths, deviceInfo.AAGUID)
-			if auth != nil {
+		if !deviceInfo.HasExtension(fido2.HMACSecretExtension) {
+			continue
+		}
+		for _, auth := range matchAAGUID(auths, deviceInfo.AAGUID) {
+			ad := &authDevice{Device: device, DeviceInfo: deviceInfo, Auth: auth}
+			ok, err := probeCredential(ctx, plugin, device, auth)
+			if err != nil {
+				logger.Debugf("Failed to probe device %s: %v", device.Path, err)
+				unprobed = append(unprobed, ad)
+				continue
+			}
+			if ok {
 				logger.Debugf("Found device: %v", device.Path)
-				return &authDevice{
-					Device:     device,
-					DeviceInfo: deviceInfo,
-					Auth:       auth,
-				}, nil
+				found = append(found, ad)
 			}
 		}
 	}
-	return nil, errors.Errorf("no matching devices found")
+	found = append(found, unprobed...)
+	if len(found) == 0 {
+		return nil, errors.Errorf("no matching devices found")
+	}
+	return found, nil
+}
+
+// probeCredential checks if a device has the auth credential, with an
+// assertion without user presence (so no touch is needed).
+// Returns an error if we couldn't tell (for example, the device requires
+// user presence).
+func probeCredential(ctx context.Context, plugin fido2.FIDO2Server, device *fido2.Device, auth *Auth) (bool, error) {
+	credID, err := encoding.Decode(auth.ID, encoding.Base62)
+	if err != nil {
+		return false, errors.Wrapf(err, "credential (provision) id was invalid")
+	}
+	cdh := bytes.Repeat([]byte{0x00}, 32) // No client data
+	if _, err := plugin.Assertion(ctx, &fido2.AssertionRequest{
+		Device:         device.Path,
+		RPID:           "getchill.app",
+		ClientDataHash: cdh[:],
+		CredentialIDs:  [][]byte{credID},
+		UP:             "false",
+	}); err != nil {
+		if isFIDO2Error(err, "no credentials") {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// isFIDO2Error returns true if the error (from the plugin) is a libfido2 error
+// with msg, for example "no credentials" (FIDO_ERR_NO_CREDENTIALS).
+func isFIDO2Error(err error, msg string) bool {
+	s := strings.ToLower(strings.ReplaceAll(err.Error(), "_", " "))
+	return strings.Contains(s, msg)
 }
 
 func hmacSecret(ctx context.Context, plugin fido2.FIDO2Server, auths []*Auth, pin string) (*Auth, *[32]byte, error) {
+	ad, key, err := hmacSecretDevice(ctx, plugin, auths, pin, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ad.Auth, key, nil
+}
+
+// hmacSecretDevice gets the hmac-secret from the first device (see findAuths)
+// that has it, returning the device and auth used.
+// If the PIN is wrong, the other devices aren't tried.
+// hmacSecretDevice gets the hmac-secret from devices with matching auths (see
+// findAuths), trying each device and credential until open accepts the key
+// (if open is nil, the first key is accepted).
+// If keys were returned but none were accepted, returns ErrInvalidAuth.
+func hmacSecretDevice(ctx context.Context, plugin fido2.FIDO2Server, auths []*Auth, pin string, open func(ad *authDevice, key *[32]byte) bool) (*authDevice, *[32]byte, error) {
 	if plugin == nil {
 		return nil, nil, errors.Errorf("fido2 plugin not available")
 	}
 
 	logger.Debugf("Looking for device with a matching credential...")
-	authDevice, err := findAuth(ctx, plugin, auths)
+	authDevices, err := findAuths(ctx, plugin, auths)
 	if err != nil {
 		return nil, nil, err
 	}
-	if authDevice.Auth == nil {
-		return nil, nil, errors.Errorf("device has no matching auth")
+
+	var lastErr error
+	for _, ad := range authDevices {
+		key, err := deviceHMACSecret(ctx, plugin, ad, pin)
+		if err != nil {
+			if isFIDO2Error(err, "pin ") {
+				return nil, nil, err
+			}
+			logger.Infof("Failed to get hmac-secret from %s: %v", ad.Device.Path, err)
+			lastErr = err
+			continue
+		}
+		if open != nil && !open(ad, key) {
+			logger.Infof("Hmac-secret from %s didn't match auth %s", ad.Device.Path, ad.Auth.ID)
+			lastErr = ErrInvalidAuth
+			continue
+		}
+		return ad, key, nil
 	}
+	return nil, nil, lastErr
+}
 
-	credID, err := encoding.Decode(authDevice.Auth.ID, encoding.Base62)
+func deviceHMACSecret(ctx context.Context, plugin fido2.FIDO2Server, ad *authDevice, pin string) (*[32]byte, error) {
+	credID, err := encoding.Decode(ad.Auth.ID, encoding.Base62)
 	if err != nil {
-		return nil, nil, errors.Wrapf(err, "credential (provision) id was invalid")
+		return nil, errors.Wrapf(err, "credential (provision) id was invalid")
 	}
 
 	logger.Debugf("Getting hmac-secret...")
@@ -228,20 +360,20 @@ func hmacSecret(ctx context.Context, plugin fido2.FIDO2Server, auths []*Auth, pi
 		Name: "getchill.app",
 	}
 	secretResp, err := plugin.HMACSecret(ctx, &fido2.HMACSecretRequest{
-		Device:         authDevice.Device.Path,
+		Device:         ad.Device.Path,
 		PIN:            pin,
 		ClientDataHash: cdh[:],
 		RPID:           rp.ID,
 		CredentialIDs:  [][]byte{credID},
-		Salt:           authDevice.Auth.Salt,
+		Salt:           ad.Auth.Salt,
 	})
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	if len(secretResp.HMACSecret) != 32 {
-		return nil, nil, errors.Errorf("invalid hmac-secret key length")
+		return nil, errors.Errorf("invalid hmac-secret key length")
 	}
 
-	return authDevice.Auth, keys.Bytes32(secretResp.HMACSecret), nil
+	return keys.Bytes32(secretResp.HMACSecret), nil
 }
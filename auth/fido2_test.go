@@ -2,6 +2,8 @@ package auth_test
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -11,7 +13,9 @@ import (
 	"github.com/getchill-app/keyring/auth"
 	"github.com/getchill-app/keyring/auth/api"
 	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
 	"github.com/keys-pub/keys-ext/auth/fido2"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -51,6 +55,7 @@ func TestFIDO2(t *testing.T) {
 	auths, err := db.ListByType(api.FIDO2HMACSecretType)
 	require.NoError(t, err)
 	require.Equal(t, 1, len(auths))
+	require.Equal(t, hs.Product, auths[0].Product)
 
 	t.Logf("Auth...")
 	out, mko, err := db.FIDO2HMACSecret(context.TODO(), fido2Plugin, pin)
@@ -58,3 +63,157 @@ func TestFIDO2(t *testing.T) {
 	require.Equal(t, mk, mko)
 	require.Equal(t, out.ID, reg.ID)
 }
+
+// testDevice is a fake FIDO2 device.
+type testDevice struct {
+	path   string
+	aaguid string
+	secret []byte
+	creds  map[string]bool
+	// noProbe if the device doesn't support assertions without user presence.
+	noProbe bool
+	// anyCred if the device returns an hmac-secret for any credential.
+	anyCred bool
+}
+
+// testFIDO2 is a fake FIDO2 plugin.
+type testFIDO2 struct {
+	fido2.UnimplementedFIDO2Server
+	devices []*testDevice
+	used    []string
+}
+
+func (f *testFIDO2) device(path string) (*testDevice, error) {
+	for _, d := range f.devices {
+		if d.path == path {
+			return d, nil
+		}
+	}
+	return nil, errors.Errorf("device not found")
+}
+
+func (f *testFIDO2) Devices(ctx context.Context, req *fido2.DevicesRequest) (*fido2.DevicesResponse, error) {
+	devices := []*fido2.Device{}
+	for _, d := range f.devices {
+		devices = append(devices, &fido2.Device{Path: d.path, Product: "Test Key"})
+	}
+	return &fido2.DevicesResponse{Devices: devices}, nil
+}
+
+func (f *testFIDO2) DeviceInfo(ctx context.Context, req *fido2.DeviceInfoRequest) (*fido2.DeviceInfoResponse, error) {
+	d, err := f.device(req.Device)
+	if err != nil {
+		return nil, err
+	}
+	return &fido2.DeviceInfoResponse{Info: &fido2.DeviceInfo{
+		AAGUID:     d.aaguid,
+		Extensions: []string{string(fido2.HMACSecretExtension)},
+	}}, nil
+}
+
+func (f *testFIDO2) GenerateHMACSecret(ctx context.Context, req *fido2.GenerateHMACSecretRequest) (*fido2.GenerateHMACSecretResponse, error) {
+	d, err := f.device(req.Device)
+	if err != nil {
+		return nil, err
+	}
+	credID := keys.RandBytes(32)
+	d.creds[string(credID)] = true
+	return &fido2.GenerateHMACSecretResponse{CredentialID: credID}, nil
+}
+
+func (f *testFIDO2) Assertion(ctx context.Context, req *fido2.AssertionRequest) (*fido2.AssertionResponse, error) {
+	d, err := f.device(req.Device)
+	if err != nil {
+		return nil, err
+	}
+	if d.noProbe {
+		return nil, errors.Errorf("unsupported option")
+	}
+	if !d.creds[string(req.CredentialIDs[0])] {
+		return nil, errors.Errorf("no credentials")
+	}
+	return &fido2.AssertionResponse{Assertion: &fido2.Assertion{}}, nil
+}
+
+func (f *testFIDO2) HMACSecret(ctx context.Context, req *fido2.HMACSecretRequest) (*fido2.HMACSecretResponse, error) {
+	f.used = append(f.used, req.Device)
+	d, err := f.device(req.Device)
+	if err != nil {
+		return nil, err
+	}
+	if req.PIN != "12345" {
+		return nil, errors.Errorf("pin invalid")
+	}
+	if !d.creds[string(req.CredentialIDs[0])] && !d.anyCred {
+		return nil, errors.Errorf("no credentials")
+	}
+	h := hmac.New(sha256.New, d.secret)
+	_, _ = h.Write(req.CredentialIDs[0])
+	_, _ = h.Write(req.Salt)
+	return &fido2.HMACSecretResponse{HMACSecret: h.Sum(nil)}, nil
+}
+
+func newTestDevice(path string, aaguid string) *testDevice {
+	return &testDevice{path: path, aaguid: aaguid, secret: keys.RandBytes(32), creds: map[string]bool{}}
+}
+
+func TestFIDO2SameModel(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+	ctx := context.TODO()
+	mk := testutil.Seed(0x01)
+	pin := "12345"
+
+	// Two devices of the same model (same AAGUID)
+	plugin := &testFIDO2{devices: []*testDevice{
+		newTestDevice("/dev/1", "aaguid1"),
+		newTestDevice("/dev/2", "aaguid1"),
+	}}
+	hs, err := auth.GenerateFIDO2HMACSecret(ctx, plugin, pin, "/dev/2", "test")
+	require.NoError(t, err)
+	reg, err := db.RegisterFIDO2HMACSecret(ctx, plugin, hs, mk, pin)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/dev/2"}, plugin.used)
+
+	plugin.used = nil
+	out, mko, device, err := db.FIDO2HMACSecretDevice(ctx, plugin, pin)
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, reg.ID, out.ID)
+	require.Equal(t, "/dev/2", device.Path)
+	require.Equal(t, []string{"/dev/2"}, plugin.used)
+
+	// Devices that can't be probed are tried (in order)
+	plugin.devices[0].noProbe = true
+	plugin.devices[1].noProbe = true
+	plugin.used = nil
+	_, mko, device, err = db.FIDO2HMACSecretDevice(ctx, plugin, pin)
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, "/dev/2", device.Path)
+	require.Equal(t, []string{"/dev/1", "/dev/2"}, plugin.used)
+
+	// Devices are tried until one opens the key
+	plugin.devices[0].anyCred = true
+	plugin.used = nil
+	_, mko, device, err = db.FIDO2HMACSecretDevice(ctx, plugin, pin)
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, "/dev/2", device.Path)
+	require.Equal(t, []string{"/dev/1", "/dev/2"}, plugin.used)
+	plugin.devices[0].anyCred = false
+
+	// Wrong PIN isn't tried on other devices
+	plugin.used = nil
+	_, _, err = db.FIDO2HMACSecret(ctx, plugin, "00000")
+	require.EqualError(t, err, "pin invalid")
+	require.Equal(t, []string{"/dev/1"}, plugin.used)
+
+	// Device removed
+	plugin.devices = plugin.devices[:1]
+	plugin.devices[0].noProbe = false
+	_, _, err = db.FIDO2HMACSecret(ctx, plugin, pin)
+	require.EqualError(t, err, "no matching devices found")
+}
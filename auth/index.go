@@ -0,0 +1,635 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/encoding"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v4"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// ErrLocked if an operation requires the master key (an unlock or register).
+var ErrLocked = errors.New("auth is locked")
+
+// ErrIndexInvalid if the auth index can't be opened with the master key.
+var ErrIndexInvalid = errors.New("auth index is invalid")
+
+// Verification is the result of verifying auth methods against the auth
+// index.
+type Verification struct {
+	// Modified are IDs of auth methods that were changed.
+	Modified []string
+	// Added are IDs of auth methods that aren't in the index.
+	Added []string
+	// Missing are IDs of auth methods in the index that were removed.
+	Missing []string
+	// IndexMissing if the index itself was removed (see IndexID).
+	IndexMissing bool
+	// PolicyChanged if the multi-factor policy (see SetMultiFactorRequired)
+	// doesn't match the index.
+	PolicyChanged bool
+}
+
+// OK returns true if there were no changes.
+func (v *Verification) OK() bool {
+	return len(v.Modified) == 0 && len(v.Added) == 0 && len(v.Missing) == 0 && !v.IndexMissing && !v.PolicyChanged
+}
+
+// ErrTampered if auth methods were changed outside of this package.
+type ErrTampered struct {
+	*Verification
+}
+
+func (e ErrTampered) Error() string {
+	changes := []string{}
+	if e.IndexMissing {
+		changes = append(changes, "index missing")
+	}
+	if len(e.Modified) > 0 {
+		changes = append(changes, fmt.Sprintf("modified %s", strings.Join(e.Modified, ", ")))
+	}
+	if len(e.Added) > 0 {
+		changes = append(changes, fmt.Sprintf("added %s", strings.Join(e.Added, ", ")))
+	}
+	if len(e.Missing) > 0 {
+		changes = append(changes, fmt.Sprintf("missing %s", strings.Join(e.Missing, ", ")))
+	}
+	if e.PolicyChanged {
+		changes = append(changes, "policy changed")
+	}
+	return fmt.Sprintf("auth was tampered with (%s)", strings.Join(changes, "; "))
+}
+
+// authIndex maps auth ID to a digest of its security relevant fields.
+// It is sealed with a key derived from the master key, and stored in config,
+// in one of the index slots (see indexSlots).
+type authIndex struct {
+	// ID is random, created with the index (see IndexID).
+	ID      string            `msgpack:"id"`
+	Digests map[string][]byte `msgpack:"digests"`
+	// MultiFactorRequired (see SetMultiFactorRequired).
+	MultiFactorRequired bool `msgpack:"mfr,omitempty"`
+	// Decoy is the index for the decoy master key, if a duress password is
+	// registered (see RegisterDuressPassword).
+	Decoy *decoyIndex `msgpack:"decoy,omitempty"`
+}
+
+// decoyIndex is how the index for the decoy master key is found from the
+// index for the master key.
+type decoyIndex struct {
+	// Key is the decoy index key.
+	Key []byte `msgpack:"key"`
+	// DuressID is the ID of the duress password auth.
+	DuressID string `msgpack:"duressId"`
+}
+
+// indexSlots are the config keys for the index for the master key, and for
+// the decoy master key (see RegisterDuressPassword). Which is which is
+// random, and an unused slot has random bytes, so it doesn't reveal if there
+// is a duress password.
+var indexSlots = []string{"authIndex", "authIndex2"}
+
+// indexPadSize is the size the sealed index is padded to (a multiple of), so
+// index slots are the same size.
+const indexPadSize = 4096
+
+// sealedIndexSize is the smallest sealed index (nonce and overhead).
+const sealedIndexSize = 24 + secretbox.Overhead + indexPadSize
+
+func errIndexMissing() error {
+	return ErrTampered{&Verification{Modified: []string{}, Added: []string{}, Missing: []string{}, IndexMissing: true}}
+}
+
+// authDigest is a digest of the auth fields (as stored) that must not change
+// without the master key. Names and usage aren't included, unless sealed.
+func authDigest(auth *Auth) ([]byte, error) {
+	fields := []interface{}{
+		auth.ID, auth.EncryptedKey, auth.Type, auth.Salt, auth.AAGUID, auth.NoPin,
+		auth.KDF, auth.KDFTime, auth.KDFMemory, auth.KDFThreads, auth.Product,
+	}
+	if len(auth.Meta) > 0 {
+		fields = append(fields, auth.Meta)
+	}
+	if auth.KID != "" {
+		fields = append(fields, auth.KID)
+	}
+	if len(auth.Fingerprint) > 0 {
+		fields = append(fields, auth.Fingerprint)
+	}
+	if auth.ExpiresAt != 0 {
+		fields = append(fields, auth.ExpiresAt)
+	}
+	if len(auth.Factors) > 0 {
+		fields = append(fields, auth.Factors)
+	}
+	if len(auth.Shares) > 0 {
+		fields = append(fields, auth.Threshold, auth.Shares)
+	}
+	b, err := msgpack.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(b)
+	return h[:], nil
+}
+
+func otherSlot(slot string) string {
+	if slot == indexSlots[0] {
+		return indexSlots[1]
+	}
+	return indexSlots[0]
+}
+
+func indexKey(mk *[32]byte) *[32]byte {
+	return keys.Bytes32(keys.HKDFSHA256(mk[:], 32, nil, []byte("keyring/auth/index")))
+}
+
+// Verify checks auth methods against the auth index, reporting any that were
+// modified, added or removed outside of this package.
+// Requires the master key from an unlock or register, otherwise returns
+// ErrLocked.
+func (d *DB) Verify() (*Verification, error) {
+	if d.indexKey == nil {
+		return nil, ErrLocked
+	}
+	auths, err := d.list()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := getIndex(d.db, d.indexSlot, d.indexKey)
+	if err != nil {
+		return nil, err
+	}
+	if idx == nil {
+		return nil, errIndexMissing()
+	}
+	return d.checkIndex(auths, idx, d.indexSlot, d.decoy)
+}
+
+// IndexID returns the ID of the auth index, which is random and created with
+// the index.
+// If the index is removed, a new index (with a different ID) is created on the
+// next unlock, so callers can detect it by keeping the ID (in encrypted
+// storage) and comparing it after each unlock.
+// Requires the master key from an unlock or register, otherwise returns
+// ErrLocked.
+func (d *DB) IndexID() (string, error) {
+	if d.indexKey == nil {
+		return "", ErrLocked
+	}
+	idx, err := getIndex(d.db, d.indexSlot, d.indexKey)
+	if err != nil {
+		return "", err
+	}
+	if idx == nil {
+		return "", errIndexMissing()
+	}
+	return idx.ID, nil
+}
+
+// Trust re-creates the auth index from the current auth methods, accepting
+// any changes reported by Verify.
+// Requires the master key from an unlock or register, otherwise returns
+// ErrLocked.
+func (d *DB) Trust() error {
+	if d.indexKey == nil {
+		return ErrLocked
+	}
+	auths, err := d.list()
+	if err != nil {
+		return err
+	}
+	return Transact(d.db, func(tx *sqlx.Tx) error {
+		idx, err := getIndexTx(tx, d.indexSlot, d.indexKey)
+		if err != nil {
+			return err
+		}
+		if idx == nil {
+			return errIndexMissing()
+		}
+		trusted, err := newIndex(idx.ID, auths)
+		if err != nil {
+			return err
+		}
+		trusted.MultiFactorRequired = idx.MultiFactorRequired
+		trusted.Decoy = idx.Decoy
+		if !d.decoy {
+			if err := setMultiFactorRequiredHintTx(tx, idx.MultiFactorRequired); err != nil {
+				return err
+			}
+		}
+		if err := setIndexTx(tx, d.indexSlot, d.indexKey, trusted); err != nil {
+			return err
+		}
+		return d.updateDecoyIndexTx(tx, trusted, func(decoy *authIndex) error {
+			decoy.Digests = trusted.Digests
+			return nil
+		})
+	})
+}
+
+// Lock clears the master key derived keys, until the next unlock or register.
+func (d *DB) Lock() {
+	d.indexKey = nil
+	d.metaKey = nil
+	d.indexSlot = ""
+	d.decoy = false
+}
+
+// open derives the index key from the master key and verifies auth methods
+// against the index.
+// If there is no index (the auth db is new, or from an earlier version), the
+// index is created from the current auth methods (trust on first use), with a
+// new ID (see IndexID). If there are auth methods, the master key must be from
+// an unlock (auth is specified), so an index isn't created with a wrong master
+// key, otherwise returns ErrLocked.
+// If auth is specified (the auth used to unlock) and it was modified or added
+// outside of this package, returns ErrTampered. For other changes, the derived
+// keys are kept, so the changes can be checked (Verify) and accepted (Trust),
+// but returns ErrTampered.
+// For a decoy master key (see RegisterDuressPassword), the decoy index is
+// used. Otherwise, auth methods are then sealed or unsealed to match
+// WithSealedMetadata.
+func (d *DB) open(mk *[32]byte, auth *Auth) error {
+	key := indexKey(mk)
+	auths, err := d.list()
+	if err != nil {
+		return err
+	}
+	slot, idx, err := findIndex(d.db, key)
+	if err != nil {
+		return err
+	}
+	if idx == nil {
+		if auth == nil && len(auths) > 0 {
+			return ErrLocked
+		}
+		logger.Infof("Creating auth index")
+		idx, err = newIndex(encoding.MustEncode(keys.RandBytes(32), encoding.Base62), auths)
+		if err != nil {
+			return err
+		}
+		if err := Transact(d.db, func(tx *sqlx.Tx) error {
+			if err := setIndexTx(tx, slot, key, idx); err != nil {
+				return err
+			}
+			return fillSlotTx(tx, otherSlot(slot), false)
+		}); err != nil {
+			return err
+		}
+		d.indexKey, d.metaKey, d.indexSlot, d.decoy = key, metaKey(mk), slot, false
+		return d.migrateSealed()
+	}
+	// The decoy index doesn't reference a decoy index, and there is a duress
+	// password for it.
+	decoy := idx.Decoy == nil && hasDuress(auths, mk)
+	v, err := d.checkIndex(auths, idx, slot, decoy)
+	if err != nil {
+		return err
+	}
+	if auth != nil && (contains(v.Modified, auth.ID) || contains(v.Added, auth.ID)) {
+		return ErrTampered{v}
+	}
+	d.indexKey, d.metaKey, d.indexSlot, d.decoy = key, metaKey(mk), slot, decoy
+	if !v.OK() {
+		return ErrTampered{v}
+	}
+	if decoy {
+		// Auth methods are sealed with the master key.
+		return nil
+	}
+	if idx.Decoy == nil {
+		// Fill the other slot, if it's empty (from an earlier version).
+		if err := Transact(d.db, func(tx *sqlx.Tx) error {
+			return fillSlotTx(tx, otherSlot(slot), false)
+		}); err != nil {
+			return err
+		}
+	}
+	return d.migrateSealed()
+}
+
+// openDecoy derives the index key from a decoy master key (see duress), if
+// there is a decoy index, without verifying auth methods.
+// A duress password registered with an earlier version has no decoy index,
+// and stays locked.
+func (d *DB) openDecoy(dmk *[32]byte) error {
+	key := indexKey(dmk)
+	slot, idx, err := findIndex(d.db, key)
+	if err != nil && !errors.Is(err, ErrIndexInvalid) {
+		return err
+	}
+	if idx == nil {
+		return nil
+	}
+	d.indexKey, d.metaKey, d.indexSlot, d.decoy = key, metaKey(dmk), slot, true
+	return nil
+}
+
+func hasDuress(auths []*Auth, mk *[32]byte) bool {
+	for _, auth := range auths {
+		if isDuress(auth, mk) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateIndexTx updates the auth index digests, if we have the index key.
+// The decoy index (if any) is also updated, so changes with the master key
+// aren't reported with the decoy master key.
+func (d *DB) updateIndexTx(tx *sqlx.Tx, fn func(digests map[string][]byte) error) error {
+	if d.indexKey == nil {
+		return nil
+	}
+	idx, err := getIndexTx(tx, d.indexSlot, d.indexKey)
+	if err != nil {
+		return err
+	}
+	if idx == nil {
+		return errIndexMissing()
+	}
+	if err := fn(idx.Digests); err != nil {
+		return err
+	}
+	if err := setIndexTx(tx, d.indexSlot, d.indexKey, idx); err != nil {
+		return err
+	}
+	if idx.Decoy == nil && !d.decoy {
+		// Keep the unused slot the same size.
+		return fillSlotTx(tx, otherSlot(d.indexSlot), false)
+	}
+	return d.updateDecoyIndexTx(tx, idx, func(decoy *authIndex) error {
+		return fn(decoy.Digests)
+	})
+}
+
+// updateDecoyIndexTx updates the decoy index referenced by idx, if any.
+func (d *DB) updateDecoyIndexTx(tx *sqlx.Tx, idx *authIndex, fn func(decoy *authIndex) error) error {
+	if idx.Decoy == nil {
+		return nil
+	}
+	key := keys.Bytes32(idx.Decoy.Key)
+	slot := otherSlot(d.indexSlot)
+	decoy, err := getIndexTx(tx, slot, key)
+	if err != nil || decoy == nil {
+		logger.Warningf("Failed to open decoy index: %v", err)
+		return nil
+	}
+	if err := fn(decoy); err != nil {
+		return err
+	}
+	return setIndexTx(tx, slot, key, decoy)
+}
+
+// fillSlotTx sets an unused index slot to random bytes the size of the other
+// slot (see indexSlots), if it's smaller, or if overwrite.
+func fillSlotTx(tx *sqlx.Tx, slot string, overwrite bool) error {
+	existing, err := getSlotTx(tx, slot)
+	if err != nil {
+		return err
+	}
+	other, err := getSlotTx(tx, otherSlot(slot))
+	if err != nil {
+		return err
+	}
+	size := len(other)
+	if size < sealedIndexSize {
+		size = sealedIndexSize
+	}
+	if !overwrite && len(existing) >= size {
+		return nil
+	}
+	return setSlotTx(tx, slot, keys.RandBytes(size))
+}
+
+func newIndex(id string, auths []*Auth) (*authIndex, error) {
+	idx := &authIndex{ID: id, Digests: map[string][]byte{}}
+	for _, auth := range auths {
+		digest, err := authDigest(auth)
+		if err != nil {
+			return nil, err
+		}
+		idx.Digests[auth.ID] = digest
+	}
+	return idx, nil
+}
+
+// checkIndex verifies auths against the index (see verifyIndex), and the
+// multi-factor policy hint (see multiFactorRequiredHint).
+// Changes made with the decoy master key (see RegisterDuressPassword) are in
+// the decoy index, so they aren't reported. The policy hint is only for the
+// master key, so it isn't checked for a decoy index.
+func (d *DB) checkIndex(auths []*Auth, idx *authIndex, slot string, decoy bool) (*Verification, error) {
+	v, err := verifyIndex(auths, idx)
+	if err != nil {
+		return nil, err
+	}
+	if idx.Decoy != nil {
+		didx, err := getIndex(d.db, otherSlot(slot), keys.Bytes32(idx.Decoy.Key))
+		if err != nil {
+			logger.Warningf("Failed to open decoy index: %v", err)
+		}
+		if didx != nil {
+			if v, err = excludeDecoy(v, auths, didx); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !decoy {
+		hint, err := multiFactorRequiredHint(d.db)
+		if err != nil {
+			return nil, err
+		}
+		v.PolicyChanged = hint != idx.MultiFactorRequired
+	}
+	return v, nil
+}
+
+// excludeDecoy removes changes from v that match the decoy index.
+func excludeDecoy(v *Verification, auths []*Auth, decoy *authIndex) (*Verification, error) {
+	dv, err := verifyIndex(auths, decoy)
+	if err != nil {
+		return nil, err
+	}
+	out := &Verification{Modified: []string{}, Added: []string{}, Missing: []string{}, IndexMissing: v.IndexMissing}
+	for _, id := range v.Modified {
+		if contains(dv.Modified, id) || contains(dv.Added, id) {
+			out.Modified = append(out.Modified, id)
+		}
+	}
+	for _, id := range v.Added {
+		if contains(dv.Modified, id) || contains(dv.Added, id) {
+			out.Added = append(out.Added, id)
+		}
+	}
+	for _, id := range v.Missing {
+		if _, ok := decoy.Digests[id]; ok {
+			out.Missing = append(out.Missing, id)
+		}
+	}
+	return out, nil
+}
+
+func verifyIndex(auths []*Auth, idx *authIndex) (*Verification, error) {
+	v := &Verification{Modified: []string{}, Added: []string{}, Missing: []string{}}
+	found := map[string]bool{}
+	for _, auth := range auths {
+		found[auth.ID] = true
+		digest, ok := idx.Digests[auth.ID]
+		if !ok {
+			v.Added = append(v.Added, auth.ID)
+			continue
+		}
+		current, err := authDigest(auth)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(digest, current) {
+			v.Modified = append(v.Modified, auth.ID)
+		}
+	}
+	for id := range idx.Digests {
+		if !found[id] {
+			v.Missing = append(v.Missing, id)
+		}
+	}
+	sort.Strings(v.Modified)
+	sort.Strings(v.Added)
+	sort.Strings(v.Missing)
+	return v, nil
+}
+
+func contains(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// findIndex returns the index (and its slot) that opens with key.
+// If there isn't one, returns an empty slot for a new index (and a nil index),
+// or if there are no empty slots, ErrIndexInvalid.
+func findIndex(db *sqlx.DB, key *[32]byte) (string, *authIndex, error) {
+	empty := []string{}
+	for _, slot := range indexSlots {
+		encrypted, err := getConfigBytes(db, slot)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(encrypted) == 0 {
+			empty = append(empty, slot)
+			continue
+		}
+		idx, err := openIndex(encrypted, key)
+		if err != nil {
+			continue
+		}
+		return slot, idx, nil
+	}
+	if len(empty) == 0 {
+		return "", nil, ErrIndexInvalid
+	}
+	if len(empty) == len(indexSlots) {
+		return indexSlots[int(keys.RandBytes(1)[0])%len(indexSlots)], nil, nil
+	}
+	return empty[0], nil, nil
+}
+
+func getIndex(db *sqlx.DB, slot string, key *[32]byte) (*authIndex, error) {
+	encrypted, err := getConfigBytes(db, slot)
+	if err != nil {
+		return nil, err
+	}
+	return openIndex(encrypted, key)
+}
+
+func getIndexTx(tx *sqlx.Tx, slot string, key *[32]byte) (*authIndex, error) {
+	encrypted, err := getSlotTx(tx, slot)
+	if err != nil {
+		return nil, err
+	}
+	return openIndex(encrypted, key)
+}
+
+func getSlotTx(tx *sqlx.Tx, slot string) ([]byte, error) {
+	var value string
+	if err := tx.Get(&value, "SELECT value FROM config WHERE key = $1", slot); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return encoding.DecodeBase64(value)
+}
+
+func setSlotTx(tx *sqlx.Tx, slot string, b []byte) error {
+	if _, err := tx.Exec("INSERT OR REPLACE INTO config (key, value) VALUES ($1, $2)", slot, encoding.MustEncode(b, encoding.Base64)); err != nil {
+		return errors.Wrapf(err, "failed to set config")
+	}
+	return nil
+}
+
+// openIndex opens a sealed index, padded (see setIndexTx), or from an earlier
+// version, not padded.
+func openIndex(encrypted []byte, key *[32]byte) (*authIndex, error) {
+	if len(encrypted) == 0 {
+		return nil, nil
+	}
+	b, ok := secretBoxOpen(encrypted, key)
+	if !ok {
+		return nil, ErrIndexInvalid
+	}
+	if len(b) > 5 && b[0] == 0x01 {
+		n := int(binary.BigEndian.Uint32(b[1:5]))
+		if n > len(b)-5 {
+			return nil, ErrIndexInvalid
+		}
+		b = b[5 : 5+n]
+	}
+	var idx authIndex
+	if err := msgpack.Unmarshal(b, &idx); err != nil {
+		return nil, ErrIndexInvalid
+	}
+	if idx.ID == "" {
+		return nil, ErrIndexInvalid
+	}
+	if idx.Digests == nil {
+		idx.Digests = map[string][]byte{}
+	}
+	return &idx, nil
+}
+
+// setIndexTx seals the index with a version byte (0x01, which a msgpack map
+// doesn't start with) and length, padded to a multiple of indexPadSize, and
+// to at least the size of the other slot.
+func setIndexTx(tx *sqlx.Tx, slot string, key *[32]byte, idx *authIndex) error {
+	b, err := msgpack.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	other, err := getSlotTx(tx, otherSlot(slot))
+	if err != nil {
+		return err
+	}
+	size := indexPadSize
+	for size < len(b)+5 || size < len(other)-sealedIndexSize+indexPadSize {
+		size += indexPadSize
+	}
+	padded := make([]byte, size)
+	padded[0] = 0x01
+	binary.BigEndian.PutUint32(padded[1:5], uint32(len(b)))
+	copy(padded[5:], b)
+	return setSlotTx(tx, slot, secretBoxSeal(padded, key))
+}
@@ -0,0 +1,180 @@
+package auth_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	path := testutil.Path()
+	defer func() { _ = os.Remove(path) }()
+
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Verify()
+	require.Equal(t, auth.ErrLocked, err)
+
+	mk := testutil.Seed(0x01)
+	paperKey := keys.RandPhrase()
+	pk, err := db.RegisterPaperKey(paperKey, mk)
+	require.NoError(t, err)
+	pk2, err := db.RegisterPaperKey(keys.RandPhrase(), mk)
+	require.NoError(t, err)
+	pk3, err := db.RegisterPaperKey(keys.RandPhrase(), mk)
+	require.NoError(t, err)
+
+	v, err := db.Verify()
+	require.NoError(t, err)
+	require.True(t, v.OK())
+
+	// Rename and usage don't affect verification
+	require.NoError(t, db.Rename(pk.ID, "test"))
+	_, _, err = db.PaperKey(paperKey)
+	require.NoError(t, err)
+	auths, err := db.List()
+	require.NoError(t, err)
+	require.Equal(t, 3, len(auths))
+
+	// Changes outside of the library
+	sdb, err := sqlx.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer sdb.Close()
+	_, err = sdb.Exec("UPDATE auth SET salt = $1 WHERE id = $2", keys.RandBytes(24), pk2.ID)
+	require.NoError(t, err)
+	_, err = sdb.Exec("DELETE FROM auth WHERE id = $1", pk3.ID)
+	require.NoError(t, err)
+	other, err := auth.NewPaperKey(keys.RandPhrase(), keys.Rand32())
+	require.NoError(t, err)
+	insertAuth(t, sdb, other)
+
+	v, err = db.Verify()
+	require.NoError(t, err)
+	require.False(t, v.OK())
+	require.Equal(t, []string{pk2.ID}, v.Modified)
+	require.Equal(t, []string{other.ID}, v.Added)
+	require.Equal(t, []string{pk3.ID}, v.Missing)
+
+	_, err = db.List()
+	var tampered auth.ErrTampered
+	require.True(t, errors.As(err, &tampered))
+	require.Equal(t, []string{pk2.ID}, tampered.Modified)
+
+	// Unlock with an unmodified auth returns the changes, with the index key
+	// to verify and trust
+	db.Lock()
+	_, err = db.Verify()
+	require.Equal(t, auth.ErrLocked, err)
+	_, _, err = db.PaperKey(paperKey)
+	require.True(t, errors.As(err, &tampered))
+	require.Equal(t, []string{pk2.ID}, tampered.Modified)
+	require.Equal(t, []string{other.ID}, tampered.Added)
+	require.Equal(t, []string{pk3.ID}, tampered.Missing)
+
+	// Delete and trust
+	require.NoError(t, db.Delete(other.ID))
+	require.NoError(t, db.Trust())
+	v, err = db.Verify()
+	require.NoError(t, err)
+	require.True(t, v.OK())
+	db.Lock()
+	_, mko, err := db.PaperKey(paperKey)
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+}
+
+func TestVerifyUnlockTampered(t *testing.T) {
+	path := testutil.Path()
+	defer func() { _ = os.Remove(path) }()
+
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	mk := testutil.Seed(0x01)
+	_, err = db.RegisterPaperKey(keys.RandPhrase(), mk)
+	require.NoError(t, err)
+	db.Lock()
+
+	sdb, err := sqlx.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer sdb.Close()
+
+	// Auth added outside of the library for the same master key
+	paperKey := keys.RandPhrase()
+	added, err := auth.NewPaperKey(paperKey, mk)
+	require.NoError(t, err)
+	insertAuth(t, sdb, added)
+	_, _, err = db.PaperKey(paperKey)
+	var tampered auth.ErrTampered
+	require.True(t, errors.As(err, &tampered))
+	require.Equal(t, []string{added.ID}, tampered.Added)
+
+	// Auth added outside of the library for a different master key
+	paperKey = keys.RandPhrase()
+	other, err := auth.NewPaperKey(paperKey, keys.Rand32())
+	require.NoError(t, err)
+	insertAuth(t, sdb, other)
+	_, _, err = db.PaperKey(paperKey)
+	require.Equal(t, auth.ErrIndexInvalid, err)
+
+	// Register with a different master key
+	_, err = db.RegisterPaperKey(keys.RandPhrase(), keys.Rand32())
+	require.Equal(t, auth.ErrIndexInvalid, err)
+}
+
+func TestIndexMissing(t *testing.T) {
+	path := testutil.Path()
+	defer func() { _ = os.Remove(path) }()
+
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	mk := testutil.Seed(0x01)
+	paperKey := keys.RandPhrase()
+	_, err = db.RegisterPaperKey(paperKey, mk)
+	require.NoError(t, err)
+	id, err := db.IndexID()
+	require.NoError(t, err)
+
+	sdb, err := sqlx.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer sdb.Close()
+	_, err = sdb.Exec("DELETE FROM config WHERE key IN ('authIndex', 'authIndex2')")
+	require.NoError(t, err)
+
+	// Removed while unlocked
+	_, err = db.List()
+	var tampered auth.ErrTampered
+	require.True(t, errors.As(err, &tampered))
+	require.True(t, tampered.IndexMissing)
+	require.EqualError(t, err, "auth was tampered with (index missing)")
+
+	// Register doesn't create an index without an unlock
+	db.Lock()
+	_, err = db.RegisterPaperKey(keys.RandPhrase(), keys.Rand32())
+	require.Equal(t, auth.ErrLocked, err)
+
+	// Unlock creates a new index, with a different ID
+	_, _, err = db.PaperKey(paperKey)
+	require.NoError(t, err)
+	id2, err := db.IndexID()
+	require.NoError(t, err)
+	require.NotEqual(t, id, id2)
+}
+
+// insertAuth inserts auth directly into the auth table.
+func insertAuth(t *testing.T, db *sqlx.DB, a *auth.Auth) {
+	_, err := db.NamedExec(`INSERT INTO auth (id, ek, type, createdAt, salt, aaguid, nopin)
+		VALUES (:id, :ek, :type, :createdAt, :salt, :aaguid, :nopin)`, a)
+	require.NoError(t, err)
+}
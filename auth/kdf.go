@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id KDF.
+const Argon2id = "argon2id"
+
+// KDFParams are password key derivation parameters.
+type KDFParams struct {
+	Algorithm string
+	// Time is the number of iterations.
+	Time uint32
+	// Memory in KiB.
+	Memory  uint32
+	Threads uint8
+}
+
+// DefaultKDFParams are the parameters used by keys.KeyForPassword, and for
+// passwords registered without KDF parameters.
+var DefaultKDFParams = KDFParams{
+	Algorithm: Argon2id,
+	Time:      1,
+	Memory:    64 * 1024,
+	Threads:   4,
+}
+
+// Key derives a key from a password and salt.
+func (p KDFParams) Key(password string, salt []byte) (*[32]byte, error) {
+	if len(salt) < 16 {
+		return nil, errors.Errorf("not enough salt")
+	}
+	if password == "" {
+		return nil, errors.Errorf("empty password")
+	}
+	switch p.Algorithm {
+	case Argon2id:
+		if p.Time == 0 || p.Memory == 0 || p.Threads == 0 {
+			return nil, errors.Errorf("invalid kdf params")
+		}
+		var out [32]byte
+		copy(out[:], argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, 32))
+		return &out, nil
+	default:
+		return nil, errors.Errorf("unsupported kdf %q", p.Algorithm)
+	}
+}
+
+// Weaker returns true if the parameters are weaker than (or a different
+// algorithm from) the other parameters.
+func (p KDFParams) Weaker(o KDFParams) bool {
+	return p.Algorithm != o.Algorithm || p.Time < o.Time || p.Memory < o.Memory
+}
+
+// CalibrateKDF returns argon2id parameters with the specified memory (KiB) and
+// threads, increasing the time cost until a derivation on this machine takes
+// at least target.
+func CalibrateKDF(target time.Duration, memory uint32, threads uint8) (KDFParams, error) {
+	if memory == 0 || threads == 0 {
+		return KDFParams{}, errors.Errorf("invalid kdf params")
+	}
+	params := KDFParams{Algorithm: Argon2id, Time: 1, Memory: memory, Threads: threads}
+	salt := make([]byte, 16)
+	for params.Time < 1000 {
+		start := time.Now()
+		if _, err := params.Key("calibrate", salt); err != nil {
+			return KDFParams{}, err
+		}
+		elapsed := time.Since(start)
+		if elapsed >= target {
+			break
+		}
+		// Estimate the time cost to reach target, and measure again.
+		next := uint32(float64(params.Time) * float64(target) / float64(elapsed+1))
+		if next <= params.Time {
+			next = params.Time + 1
+		}
+		params.Time = next
+	}
+	logger.Debugf("Calibrated kdf %+v", params)
+	return params, nil
+}
+
+// kdfParams returns the KDF parameters for auth.
+func kdfParams(auth *Auth) KDFParams {
+	if auth.KDF == "" {
+		return DefaultKDFParams
+	}
+	return KDFParams{
+		Algorithm: auth.KDF,
+		Time:      auth.KDFTime,
+		Memory:    auth.KDFMemory,
+		Threads:   auth.KDFThreads,
+	}
+}
+
+func setKDFParams(auth *Auth, p KDFParams) {
+	auth.KDF = p.Algorithm
+	auth.KDFTime = p.Time
+	auth.KDFMemory = p.Memory
+	auth.KDFThreads = p.Threads
+}
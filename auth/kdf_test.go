@@ -0,0 +1,111 @@
+package auth_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordRehash(t *testing.T) {
+	path := testutil.Path()
+	defer func() { _ = os.Remove(path) }()
+
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	mk := testutil.Seed(0x01)
+	reg, err := db.RegisterPassword("testpassword", mk)
+	require.NoError(t, err)
+	require.Equal(t, auth.Argon2id, reg.KDF)
+	require.Equal(t, auth.DefaultKDFParams.Time, reg.KDFTime)
+	require.NoError(t, db.Close())
+
+	stronger := auth.KDFParams{Algorithm: auth.Argon2id, Time: 2, Memory: 64 * 1024, Threads: 4}
+	db, err = auth.NewDB(path, auth.WithKDFParams(stronger))
+	require.NoError(t, err)
+	defer db.Close()
+
+	out, mko, err := db.Password("testpassword")
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, reg.ID, out.ID)
+	require.Equal(t, uint32(2), out.KDFTime)
+	require.NotEqual(t, reg.Salt, out.Salt)
+
+	auths, err := db.ListByType(api.PasswordType)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(auths))
+	require.Equal(t, uint32(2), auths[0].KDFTime)
+	require.Equal(t, uint32(64*1024), auths[0].KDFMemory)
+	require.Equal(t, uint8(4), auths[0].KDFThreads)
+
+	_, mko, err = db.Password("testpassword")
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+
+	_, _, err = db.Password("invalidpassword")
+	require.EqualError(t, err, "invalid auth")
+}
+
+func TestPasswordLegacy(t *testing.T) {
+	path := testutil.Path()
+	defer func() { _ = os.Remove(path) }()
+
+	// Auth table from before KDF params were stored.
+	sdb, err := sqlx.Open("sqlite3", path)
+	require.NoError(t, err)
+	_, err = sdb.Exec(`CREATE TABLE auth (
+			id TEXT NOT NULL PRIMARY KEY, 
+			ek BLOB,
+			type TEXT,
+			createdAt TIMESTAMP,
+			salt BLOB,
+			aaguid TEXT,
+			nopin BOOL
+		);`)
+	require.NoError(t, err)
+	mk := testutil.Seed(0x01)
+	salt := keys.RandBytes(24)
+	key, err := keys.KeyForPassword("testpassword", salt)
+	require.NoError(t, err)
+	legacy, err := auth.NewPassword("unused", mk)
+	require.NoError(t, err)
+	legacy.Salt = salt
+	legacy.EncryptedKey = auth.SecretBoxSeal(mk[:], key)
+	_, err = sdb.Exec(`INSERT INTO auth (id, ek, type, createdAt, salt, aaguid, nopin) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		legacy.ID, legacy.EncryptedKey, legacy.Type, legacy.CreatedAt, legacy.Salt, "", false)
+	require.NoError(t, err)
+	require.NoError(t, sdb.Close())
+
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	out, mko, err := db.Password("testpassword")
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, legacy.ID, out.ID)
+	require.Equal(t, "", out.KDF)
+}
+
+func TestCalibrateKDF(t *testing.T) {
+	target := 50 * time.Millisecond
+	params, err := auth.CalibrateKDF(target, 8*1024, 1)
+	require.NoError(t, err)
+	require.Equal(t, auth.Argon2id, params.Algorithm)
+	require.True(t, params.Time >= 1)
+
+	start := time.Now()
+	_, err = params.Key("password", keys.RandBytes(16))
+	require.NoError(t, err)
+	require.True(t, time.Since(start) >= target/2)
+
+	_, err = auth.CalibrateKDF(target, 0, 1)
+	require.EqualError(t, err, "invalid kdf params")
+}
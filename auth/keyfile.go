@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/encoding"
+	"github.com/pkg/errors"
+)
+
+// KeyFileSize is the size of generated key files.
+const KeyFileSize = 64
+
+// minKeyFileSize is the minimum size of a key file.
+const minKeyFileSize = 32
+
+// maxKeyFileSize is the maximum size of a key file.
+const maxKeyFileSize = 1024 * 1024
+
+// GenerateKeyFile creates a random key file at path, readable only by the
+// current user.
+// Returns an error if the file already exists.
+func GenerateKeyFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(keys.RandBytes(KeyFileSize)); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// CheckKeyFilePermissions returns an error if a key file is readable or
+// writable by other users.
+func CheckKeyFilePermissions(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	if perm := fi.Mode().Perm(); perm&0077 != 0 {
+		return errors.Errorf("key file %s is accessible by other users (%04o)", path, perm)
+	}
+	return nil
+}
+
+// NewKeyFile creates key file auth.
+func NewKeyFile(r io.Reader, mk *[32]byte) (*Auth, error) {
+	b, err := readKeyFile(r)
+	if err != nil {
+		return nil, err
+	}
+	id := encoding.MustEncode(keys.RandBytes(32), encoding.Base62)
+	salt := keys.RandBytes(32)
+	ek := secretBoxSeal(mk[:], keyFileKey(b, salt))
+	return &Auth{
+		ID:           id,
+		Type:         api.KeyFileType,
+		EncryptedKey: ek,
+		Salt:         salt,
+		Fingerprint:  keyFileFingerprint(b, salt),
+		CreatedAt:    time.Now(),
+	}, nil
+}
+
+// RegisterKeyFile registers key file auth.
+func (d *DB) RegisterKeyFile(r io.Reader, mk *[32]byte) (*Auth, error) {
+	if mk == nil {
+		return nil, errors.Errorf("nil master key")
+	}
+	auth, err := NewKeyFile(r, mk)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.open(mk, nil); err != nil {
+		return nil, err
+	}
+
+	if err := d.Set(auth); err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+// RegisterKeyFilePath registers key file auth from a path.
+// If the key file is accessible by other users, a warning is logged.
+func (d *DB) RegisterKeyFilePath(path string, mk *[32]byte) (*Auth, error) {
+	f, err := openKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return d.RegisterKeyFile(f, mk)
+}
+
+// KeyFile authenticates using a key file.
+// If the key file doesn't match a registered key file, returns ErrInvalidAuth.
+// Failed attempts are limited, see WithBackoff and WithLockout; if limited,
+// returns ErrTooManyAttempts.
+func (d *DB) KeyFile(r io.Reader) (*Auth, *[32]byte, error) {
+	return d.attempt(api.KeyFileType, false, func() (*Auth, *[32]byte, error) {
+		return d.keyFile(r)
+	})
+}
+
+// VerifyKeyFile checks a key file, like KeyFile, to re-confirm it while
+// already unlocked (see attempt).
+func (d *DB) VerifyKeyFile(r io.Reader) (*Auth, *[32]byte, error) {
+	return d.attempt(api.KeyFileType, true, func() (*Auth, *[32]byte, error) {
+		return d.keyFile(r)
+	})
+}
+
+// KeyFilePath authenticates using a key file at path.
+// If the key file is accessible by other users, a warning is logged.
+func (d *DB) KeyFilePath(path string) (*Auth, *[32]byte, error) {
+	f, err := openKeyFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return d.KeyFile(f)
+}
+
+// VerifyKeyFilePath checks a key file at path, like KeyFilePath, to re-confirm
+// it while already unlocked (see attempt).
+func (d *DB) VerifyKeyFilePath(path string) (*Auth, *[32]byte, error) {
+	f, err := openKeyFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return d.VerifyKeyFile(f)
+}
+
+func (d *DB) keyFile(r io.Reader) (*Auth, *[32]byte, error) {
+	b, err := readKeyFile(r)
+	if err != nil {
+		return nil, nil, ErrInvalidAuth
+	}
+	auths, err := d.ListByType(api.KeyFileType)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to auth")
+	}
+
+	for _, auth := range auths {
+		if len(auth.Salt) == 0 {
+			continue
+		}
+		// Auth with sealed metadata has no fingerprint, so we try to open those.
+		if len(auth.Fingerprint) > 0 && !bytes.Equal(auth.Fingerprint, keyFileFingerprint(b, auth.Salt)) {
+			continue
+		}
+		mk := d.unlock(auth, keyFileKey(b, auth.Salt))
+		if mk == nil {
+			continue
+		}
+		return auth, mk, nil
+	}
+	return nil, nil, ErrInvalidAuth
+}
+
+func openKeyFile(path string) (*os.File, error) {
+	f, err := os.Open(path) // #nosec
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckKeyFilePermissions(path); err != nil {
+		logger.Warningf("%v", err)
+	}
+	return f, nil
+}
+
+func readKeyFile(r io.Reader) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxKeyFileSize+1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read key file")
+	}
+	if len(b) < minKeyFileSize {
+		return nil, errors.Errorf("key file is too small")
+	}
+	if len(b) > maxKeyFileSize {
+		return nil, errors.Errorf("key file is too large")
+	}
+	return b, nil
+}
+
+func keyFileKey(b []byte, salt []byte) *[32]byte {
+	return keys.Bytes32(keys.HKDFSHA256(b, 32, salt, []byte("keyring/auth/keyfile")))
+}
+
+// keyFileFingerprint identifies a key file (it's not the key file key).
+func keyFileFingerprint(b []byte, salt []byte) []byte {
+	return keys.HKDFSHA256(b, 32, salt, []byte("keyring/auth/keyfile/fingerprint"))
+}
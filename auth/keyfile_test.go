@@ -0,0 +1,76 @@
+package auth_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyFile(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "keyfile")
+	err = auth.GenerateKeyFile(keyFile)
+	require.NoError(t, err)
+	err = auth.GenerateKeyFile(keyFile)
+	require.Error(t, err)
+	require.NoError(t, auth.CheckKeyFilePermissions(keyFile))
+
+	mk := testutil.Seed(0x01)
+	reg, err := db.RegisterKeyFilePath(keyFile, mk)
+	require.NoError(t, err)
+	require.Equal(t, 32, len(reg.Fingerprint))
+
+	auths, err := db.ListByType(api.KeyFileType)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(auths))
+
+	out, mko, err := db.KeyFilePath(keyFile)
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, reg.ID, out.ID)
+
+	b, err := ioutil.ReadFile(keyFile)
+	require.NoError(t, err)
+	out, mko, err = db.KeyFile(bytes.NewReader(b))
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, reg.ID, out.ID)
+
+	// Wrong key file
+	_, _, err = db.KeyFile(bytes.NewReader(keys.RandBytes(64)))
+	require.EqualError(t, err, "invalid auth")
+	_, _, err = db.KeyFile(bytes.NewReader(b[:16]))
+	require.EqualError(t, err, "invalid auth")
+
+	_, _, err = db.KeyFilePath(filepath.Join(dir, "notfound"))
+	require.Error(t, err)
+}
+
+func TestKeyFilePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "keyfile")
+	err := ioutil.WriteFile(keyFile, keys.RandBytes(64), 0644)
+	require.NoError(t, err)
+	require.NoError(t, os.Chmod(keyFile, 0644))
+
+	err = auth.CheckKeyFilePermissions(keyFile)
+	require.EqualError(t, err, fmt.Sprintf("key file %s is accessible by other users (0644)", keyFile))
+}
@@ -0,0 +1,485 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys-ext/auth/fido2"
+	"github.com/keys-pub/keys/encoding"
+	"github.com/pkg/errors"
+)
+
+// ErrMultiFactorRequired if single-factor auth is registered when it's
+// disabled by policy (see SetMultiFactorRequired).
+var ErrMultiFactorRequired = errors.New("multi-factor auth required")
+
+// FactorSecret is what's needed to derive a key for a factor of multi-factor
+// auth.
+type FactorSecret struct {
+	Type     Type
+	Password string
+	PaperKey string
+	KeyFile  io.Reader
+	// FIDO2HMACSecret is required to register (it isn't needed to unlock).
+	FIDO2HMACSecret *FIDO2HMACSecret
+	PIN             string
+
+	keyFile []byte
+}
+
+// PasswordFactor is a password factor.
+func PasswordFactor(password string) *FactorSecret {
+	return &FactorSecret{Type: api.PasswordType, Password: password}
+}
+
+// PaperKeyFactor is a paper key factor.
+func PaperKeyFactor(paperKey string) *FactorSecret {
+	return &FactorSecret{Type: api.PaperKeyType, PaperKey: paperKey}
+}
+
+// KeyFileFactor is a key file factor.
+func KeyFileFactor(r io.Reader) *FactorSecret {
+	return &FactorSecret{Type: api.KeyFileType, KeyFile: r}
+}
+
+// FIDO2HMACSecretFactor is a FIDO2 hmac-secret factor.
+// The hmac-secret (see GenerateFIDO2HMACSecret) is required to register, and
+// can be nil to unlock.
+func FIDO2HMACSecretFactor(hs *FIDO2HMACSecret, pin string) *FactorSecret {
+	return &FactorSecret{Type: api.FIDO2HMACSecretType, FIDO2HMACSecret: hs, PIN: pin}
+}
+
+// RegisterMultiFactor registers multi-factor auth, where the master key is
+// encrypted with a key derived from all the factors, and each factor is
+// required to unlock.
+// The FIDO2 plugin is only needed for a FIDO2 hmac-secret factor.
+func (d *DB) RegisterMultiFactor(ctx context.Context, plugin fido2.FIDO2Server, mk *[32]byte, secrets ...*FactorSecret) (*Auth, error) {
+	if mk == nil {
+		return nil, errors.Errorf("nil master key")
+	}
+	if len(secrets) < 2 {
+		return nil, errors.Errorf("multi-factor auth requires at least 2 factors")
+	}
+	if err := prepareFactors(secrets); err != nil {
+		return nil, err
+	}
+
+	salt := keys.RandBytes(32)
+	factors := api.Factors{}
+	fkeys := [][]byte{}
+	for i, secret := range secrets {
+		factor, err := d.newFactor(secret, factorSalt(salt, i))
+		if err != nil {
+			return nil, err
+		}
+		key, err := factorKey(ctx, plugin, nil, factor, secret)
+		if err != nil {
+			return nil, err
+		}
+		factors = append(factors, factor)
+		fkeys = append(fkeys, key)
+	}
+
+	auth := &Auth{
+		ID:           encoding.MustEncode(keys.RandBytes(32), encoding.Base62),
+		Type:         api.MultiFactorType,
+		EncryptedKey: secretBoxSeal(mk[:], multiFactorKey(fkeys, salt)),
+		Salt:         salt,
+		Factors:      factors,
+		CreatedAt:    time.Now(),
+	}
+	if err := d.open(mk, nil); err != nil {
+		return nil, err
+	}
+
+	if err := d.Set(auth); err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+// MultiFactor authenticates using multiple factors, which must match (in any
+// order) the factors of a registered multi-factor auth.
+// The FIDO2 plugin is only needed for a FIDO2 hmac-secret factor.
+// Failed attempts are limited, see WithBackoff and WithLockout; if limited,
+// returns ErrTooManyAttempts.
+func (d *DB) MultiFactor(ctx context.Context, plugin fido2.FIDO2Server, secrets ...*FactorSecret) (*Auth, *[32]byte, error) {
+	return d.attempt(api.MultiFactorType, false, func() (*Auth, *[32]byte, error) {
+		return d.multiFactor(ctx, plugin, secrets)
+	})
+}
+
+// VerifyMultiFactor checks multiple factors, like MultiFactor, to re-confirm
+// them while already unlocked (see attempt).
+func (d *DB) VerifyMultiFactor(ctx context.Context, plugin fido2.FIDO2Server, secrets ...*FactorSecret) (*Auth, *[32]byte, error) {
+	return d.attempt(api.MultiFactorType, true, func() (*Auth, *[32]byte, error) {
+		return d.multiFactor(ctx, plugin, secrets)
+	})
+}
+
+// SetMultiFactorRequired sets whether multi-factor auth is required.
+// If required, other auth methods (including duress passwords) are disabled,
+// and fail to unlock (see attempt), and registering them returns
+// ErrMultiFactorRequired. They are kept, so they work again if it's no longer
+// required. (They still encrypt the master key on their own, so remove them if
+// that's a concern.)
+// The setting is kept in the auth index (see Verify), so it can't be changed
+// without the master key.
+// Requires the master key from an unlock or register, otherwise returns
+// ErrLocked.
+func (d *DB) SetMultiFactorRequired(required bool) error {
+	if d.indexKey == nil {
+		return ErrLocked
+	}
+	if required {
+		auths, err := d.List()
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, auth := range auths {
+			if auth.Type == api.MultiFactorType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.Errorf("no multi-factor auth registered")
+		}
+	}
+	return Transact(d.db, func(tx *sqlx.Tx) error {
+		idx, err := getIndexTx(tx, d.indexSlot, d.indexKey)
+		if err != nil {
+			return err
+		}
+		if idx == nil {
+			return errIndexMissing()
+		}
+		idx.MultiFactorRequired = required
+		if err := setIndexTx(tx, d.indexSlot, d.indexKey, idx); err != nil {
+			return err
+		}
+		if d.decoy {
+			// The hint is for the master key.
+			return nil
+		}
+		if err := d.updateDecoyIndexTx(tx, idx, func(decoy *authIndex) error {
+			decoy.MultiFactorRequired = required
+			return nil
+		}); err != nil {
+			return err
+		}
+		return setMultiFactorRequiredHintTx(tx, required)
+	})
+}
+
+// MultiFactorRequired returns true if multi-factor auth is required (see
+// SetMultiFactorRequired).
+// Requires the master key from an unlock or register, otherwise returns
+// ErrLocked.
+func (d *DB) MultiFactorRequired() (bool, error) {
+	if d.indexKey == nil {
+		return false, ErrLocked
+	}
+	idx, err := getIndex(d.db, d.indexSlot, d.indexKey)
+	if err != nil {
+		return false, err
+	}
+	if idx == nil {
+		return false, errIndexMissing()
+	}
+	return idx.MultiFactorRequired, nil
+}
+
+// multiFactorRequiredHint returns the policy from config, which is checked
+// before an unlock (see attempt), when the auth index can't be opened.
+// It isn't protected, so it's also checked against the index after an unlock.
+func multiFactorRequiredHint(db *sqlx.DB) (bool, error) {
+	value, err := getConfig(db, "multiFactorRequired")
+	if err != nil {
+		return false, err
+	}
+	return value == "1", nil
+}
+
+func setMultiFactorRequiredHintTx(tx *sqlx.Tx, required bool) error {
+	if !required {
+		if _, err := tx.Exec("DELETE FROM config WHERE key = $1", "multiFactorRequired"); err != nil {
+			return err
+		}
+		return nil
+	}
+	if _, err := tx.Exec("INSERT OR REPLACE INTO config (key, value) VALUES ($1, $2)", "multiFactorRequired", "1"); err != nil {
+		return errors.Wrapf(err, "failed to set config")
+	}
+	return nil
+}
+
+// checkMultiFactorTx returns ErrMultiFactorRequired if auth isn't multi-factor
+// auth and multi-factor auth is required.
+func (d *DB) checkMultiFactorTx(tx *sqlx.Tx, auth *Auth) error {
+	if d.indexKey == nil || auth.Type == api.MultiFactorType {
+		return nil
+	}
+	idx, err := getIndexTx(tx, d.indexSlot, d.indexKey)
+	if err != nil {
+		return err
+	}
+	if idx != nil && idx.MultiFactorRequired {
+		return ErrMultiFactorRequired
+	}
+	return nil
+}
+
+func (d *DB) multiFactor(ctx context.Context, plugin fido2.FIDO2Server, secrets []*FactorSecret) (*Auth, *[32]byte, error) {
+	if err := prepareFactors(secrets); err != nil {
+		return nil, nil, ErrInvalidAuth
+	}
+	auths, err := d.ListByType(api.MultiFactorType)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to auth")
+	}
+
+	for _, auth := range auths {
+		// Keys by factor (salt and credential ID) and secret index, since
+		// secrets may be tried for more than one factor.
+		cache := map[string][]byte{}
+		for _, factors := range candidateFactors(auth, len(secrets)) {
+			for _, ordering := range matchFactors(factors, secrets) {
+				fkeys := [][]byte{}
+				for i, factor := range factors {
+					ck := fmt.Sprintf("%x/%x/%d", factor.Salt, factor.CredentialID, ordering[i])
+					key, ok := cache[ck]
+					if !ok {
+						k, err := factorKey(ctx, plugin, auth, factor, secrets[ordering[i]])
+						if err != nil && !errors.Is(err, ErrInvalidAuth) {
+							return nil, nil, errors.Wrapf(err, "failed to auth")
+						}
+						key = k
+						cache[ck] = key
+					}
+					if key == nil {
+						break
+					}
+					fkeys = append(fkeys, key)
+				}
+				if len(fkeys) != len(factors) {
+					continue
+				}
+				mk := d.unlock(auth, multiFactorKey(fkeys, auth.Salt))
+				if mk == nil {
+					continue
+				}
+				return auth, mk, nil
+			}
+		}
+	}
+	return nil, nil, ErrInvalidAuth
+}
+
+// candidateFactors returns the factors auth could have for count secrets.
+// With sealed metadata (see sealFactors), only FIDO2 hmac-secret factors are
+// stored, so they could be at any position (in order), and the other factors
+// have salts derived from the auth salt and its KDF parameters.
+func candidateFactors(auth *Auth, count int) []api.Factors {
+	if auth.Type != "" || len(auth.Factors) == count {
+		return []api.Factors{auth.Factors}
+	}
+	if len(auth.Factors) > count {
+		return nil
+	}
+	candidates := []api.Factors{}
+	factors := make(api.Factors, count)
+	var place func(i int, next int)
+	place = func(i int, next int) {
+		if i == count {
+			if next == len(auth.Factors) {
+				candidates = append(candidates, append(api.Factors{}, factors...))
+			}
+			return
+		}
+		if next < len(auth.Factors) {
+			factors[i] = auth.Factors[next]
+			place(i+1, next+1)
+		}
+		if count-i > len(auth.Factors)-next {
+			factors[i] = &api.Factor{
+				Salt:       factorSalt(auth.Salt, i),
+				KDF:        auth.KDF,
+				KDFTime:    auth.KDFTime,
+				KDFMemory:  auth.KDFMemory,
+				KDFThreads: auth.KDFThreads,
+			}
+			place(i+1, next)
+		}
+	}
+	place(0, 0)
+	return candidates
+}
+
+// factorSalt derives the salt for a factor (by position) of multi-factor auth
+// from the auth salt.
+func factorSalt(salt []byte, i int) []byte {
+	return keys.HKDFSHA256(salt, 32, nil, []byte(fmt.Sprintf("keyring/auth/factor/%d", i)))
+}
+
+// newFactor creates a factor for a secret, with salt (see factorSalt) for a
+// password or key file.
+func (d *DB) newFactor(secret *FactorSecret, salt []byte) (*api.Factor, error) {
+	factor := &api.Factor{Type: secret.Type}
+	switch secret.Type {
+	case api.PasswordType:
+		factor.Salt = salt
+		factor.KDF = d.kdf.Algorithm
+		factor.KDFTime = d.kdf.Time
+		factor.KDFMemory = d.kdf.Memory
+		factor.KDFThreads = d.kdf.Threads
+	case api.PaperKeyType:
+	case api.KeyFileType:
+		factor.Salt = salt
+	case api.FIDO2HMACSecretType:
+		hs := secret.FIDO2HMACSecret
+		if hs == nil {
+			return nil, errors.Errorf("no fido2 hmac-secret")
+		}
+		if len(hs.CredentialID) < 32 {
+			return nil, errors.Errorf("invalid credential id")
+		}
+		factor.Salt = hs.Salt
+		factor.CredentialID = hs.CredentialID
+		factor.AAGUID = hs.AAGUID
+		factor.NoPin = hs.NoPin
+	default:
+		return nil, errors.Errorf("unsupported factor %s", secret.Type)
+	}
+	return factor, nil
+}
+
+// factorKey derives the key for a factor of (multi-factor) auth (nil when
+// registering), using the secret's type, since with sealed metadata the
+// factor's type is hidden (see sealFactors).
+// If the secret is invalid, returns ErrInvalidAuth.
+func factorKey(ctx context.Context, plugin fido2.FIDO2Server, auth *Auth, factor *api.Factor, secret *FactorSecret) ([]byte, error) {
+	switch secret.Type {
+	case api.PasswordType:
+		if secret.Password == "" {
+			return nil, ErrInvalidAuth
+		}
+		params := DefaultKDFParams
+		if factor.KDF != "" {
+			params = KDFParams{
+				Algorithm: factor.KDF,
+				Time:      factor.KDFTime,
+				Memory:    factor.KDFMemory,
+				Threads:   factor.KDFThreads,
+			}
+		}
+		key, err := params.Key(secret.Password, factor.Salt)
+		if err != nil {
+			return nil, err
+		}
+		return key[:], nil
+	case api.PaperKeyType:
+		key, err := encoding.PhraseToBytes(secret.PaperKey, true)
+		if err != nil {
+			return nil, ErrInvalidAuth
+		}
+		return key[:], nil
+	case api.KeyFileType:
+		return keyFileKey(secret.keyFile, factor.Salt)[:], nil
+	case api.FIDO2HMACSecretType:
+		fa := &Auth{
+			ID:     encoding.MustEncode(factor.CredentialID, encoding.Base62),
+			Type:   api.FIDO2HMACSecretType,
+			Salt:   factor.Salt,
+			AAGUID: factor.AAGUID,
+			NoPin:  factor.NoPin,
+		}
+		if auth != nil {
+			// The factor is sealed (without AAGUID) if the auth is.
+			fa.Meta = auth.Meta
+		}
+		_, key, err := hmacSecret(ctx, plugin, []*Auth{fa}, secret.PIN)
+		if err != nil {
+			return nil, err
+		}
+		return key[:], nil
+	default:
+		return nil, errors.Errorf("unsupported factor %s", secret.Type)
+	}
+}
+
+// multiFactorKey derives the key encrypting the master key from the factor
+// keys (in order).
+func multiFactorKey(fkeys [][]byte, salt []byte) *[32]byte {
+	secret := []byte{}
+	for _, key := range fkeys {
+		secret = append(secret, key...)
+	}
+	return keys.Bytes32(keys.HKDFSHA256(secret, 32, salt, []byte("keyring/auth/multi-factor")))
+}
+
+// matchFactors returns the orderings of secrets (as secret indexes, in the
+// order of factors) that could match factors.
+// With sealed metadata (see sealFactors), factor types are hidden, except for
+// FIDO2 hmac-secret (which has a credential ID), so secrets of other types can
+// match any of the other factors.
+func matchFactors(factors api.Factors, secrets []*FactorSecret) [][]int {
+	if len(factors) == 0 || len(factors) != len(secrets) {
+		return nil
+	}
+	matches := func(factor *api.Factor, secret *FactorSecret) bool {
+		if factor.Type != "" {
+			return secret.Type == factor.Type
+		}
+		return (secret.Type == api.FIDO2HMACSecretType) == (len(factor.CredentialID) > 0)
+	}
+	orderings := [][]int{}
+	used := make([]bool, len(secrets))
+	ordering := make([]int, 0, len(factors))
+	var match func(i int)
+	match = func(i int) {
+		if i == len(factors) {
+			orderings = append(orderings, append([]int{}, ordering...))
+			return
+		}
+		for j, secret := range secrets {
+			if used[j] || !matches(factors[i], secret) {
+				continue
+			}
+			used[j] = true
+			ordering = append(ordering, j)
+			match(i + 1)
+			ordering = ordering[:len(ordering)-1]
+			used[j] = false
+		}
+	}
+	match(0)
+	return orderings
+}
+
+// prepareFactors reads key files.
+func prepareFactors(secrets []*FactorSecret) error {
+	for _, secret := range secrets {
+		if secret == nil {
+			return errors.Errorf("nil factor")
+		}
+		if secret.Type == api.KeyFileType && secret.keyFile == nil {
+			if secret.KeyFile == nil {
+				return errors.Errorf("no key file")
+			}
+			b, err := readKeyFile(secret.KeyFile)
+			if err != nil {
+				return err
+			}
+			secret.keyFile = b
+		}
+	}
+	return nil
+}
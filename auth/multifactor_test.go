@@ -0,0 +1,197 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiFactor(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+	ctx := context.TODO()
+
+	mk := testutil.Seed(0x01)
+	paperKey := keys.RandPhrase()
+	keyFile := keys.RandBytes(auth.KeyFileSize)
+
+	_, err = db.RegisterMultiFactor(ctx, nil, mk, auth.PasswordFactor("testpassword"))
+	require.EqualError(t, err, "multi-factor auth requires at least 2 factors")
+
+	reg, err := db.RegisterMultiFactor(ctx, nil, mk,
+		auth.PasswordFactor("testpassword"),
+		auth.PaperKeyFactor(paperKey),
+		auth.KeyFileFactor(bytes.NewReader(keyFile)))
+	require.NoError(t, err)
+	require.Equal(t, api.MultiFactorType, reg.Type)
+	require.Equal(t, 3, len(reg.Factors))
+
+	// Factors in any order
+	out, mko, err := db.MultiFactor(ctx, nil,
+		auth.KeyFileFactor(bytes.NewReader(keyFile)),
+		auth.PaperKeyFactor(paperKey),
+		auth.PasswordFactor("testpassword"))
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, reg.ID, out.ID)
+
+	// Wrong factor
+	_, _, err = db.MultiFactor(ctx, nil,
+		auth.PasswordFactor("invalidpassword"),
+		auth.PaperKeyFactor(paperKey),
+		auth.KeyFileFactor(bytes.NewReader(keyFile)))
+	require.EqualError(t, err, "invalid auth")
+
+	// Missing factor
+	_, _, err = db.MultiFactor(ctx, nil,
+		auth.PasswordFactor("testpassword"),
+		auth.PaperKeyFactor(paperKey))
+	require.EqualError(t, err, "invalid auth")
+
+	// A single factor isn't registered on its own
+	_, _, err = db.PaperKey(paperKey)
+	require.EqualError(t, err, "invalid auth")
+}
+
+func TestMultiFactorRequired(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+	ctx := context.TODO()
+
+	mk := testutil.Seed(0x01)
+	paperKey := keys.RandPhrase()
+	_, err = db.RegisterPassword("testpassword", mk)
+	require.NoError(t, err)
+
+	err = db.SetMultiFactorRequired(true)
+	require.EqualError(t, err, "no multi-factor auth registered")
+
+	_, err = db.RegisterMultiFactor(ctx, nil, mk,
+		auth.PasswordFactor("testpassword"),
+		auth.PaperKeyFactor(paperKey))
+	require.NoError(t, err)
+	err = db.SetMultiFactorRequired(true)
+	require.NoError(t, err)
+	required, err := db.MultiFactorRequired()
+	require.NoError(t, err)
+	require.True(t, required)
+
+	// Single-factor auth is kept, but disabled, and can't be registered
+	auths, err := db.List()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(auths))
+	_, _, err = db.Password("testpassword")
+	require.Equal(t, auth.ErrInvalidAuth, err)
+	_, err = db.RegisterPaperKey(keys.RandPhrase(), mk)
+	require.Equal(t, auth.ErrMultiFactorRequired, err)
+
+	// Single-factor auth fails (as a failed attempt), without locking
+	_, _, err = db.VerifyPassword("testpassword")
+	require.Equal(t, auth.ErrInvalidAuth, err)
+	attempts, err := db.Attempts(api.PasswordType)
+	require.NoError(t, err)
+	require.Equal(t, 2, attempts.Failures)
+	required, err = db.MultiFactorRequired()
+	require.NoError(t, err)
+	require.True(t, required)
+
+	_, mko, err := db.MultiFactor(ctx, nil,
+		auth.PasswordFactor("testpassword"),
+		auth.PaperKeyFactor(paperKey))
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+
+	// Kept on trust
+	require.NoError(t, db.Trust())
+	required, err = db.MultiFactorRequired()
+	require.NoError(t, err)
+	require.True(t, required)
+
+	// Removing the policy hint (from config) doesn't enable single-factor auth
+	sdb, err := sqlx.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer sdb.Close()
+	_, err = sdb.Exec("DELETE FROM config WHERE key = 'multiFactorRequired'")
+	require.NoError(t, err)
+	_, _, err = db.Password("testpassword")
+	require.Equal(t, auth.ErrInvalidAuth, err)
+	v, err := db.Verify()
+	require.NoError(t, err)
+	require.True(t, v.PolicyChanged)
+	require.NoError(t, db.Trust())
+	v, err = db.Verify()
+	require.NoError(t, err)
+	require.True(t, v.OK())
+
+	// Requires unlock
+	db.Lock()
+	err = db.SetMultiFactorRequired(false)
+	require.Equal(t, auth.ErrLocked, err)
+	_, err = db.MultiFactorRequired()
+	require.Equal(t, auth.ErrLocked, err)
+
+	// Single-factor auth works again, if not required
+	_, _, err = db.MultiFactor(ctx, nil,
+		auth.PasswordFactor("testpassword"),
+		auth.PaperKeyFactor(paperKey))
+	require.NoError(t, err)
+	require.NoError(t, db.SetMultiFactorRequired(false))
+	_, mko, err = db.Password("testpassword")
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+}
+
+func TestMultiFactorSealed(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path, auth.WithSealedMetadata())
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+	ctx := context.TODO()
+
+	mk := testutil.Seed(0x01)
+	paperKey := keys.RandPhrase()
+	keyFile := keys.RandBytes(auth.KeyFileSize)
+	reg, err := db.RegisterMultiFactor(ctx, nil, mk,
+		auth.PasswordFactor("testpassword"),
+		auth.PaperKeyFactor(paperKey),
+		auth.KeyFileFactor(bytes.NewReader(keyFile)))
+	require.NoError(t, err)
+
+	// Factors are sealed (salts are derived from the auth salt)
+	sdb, err := sqlx.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer sdb.Close()
+	var stored api.Factors
+	err = sdb.Get(&stored, "SELECT factors FROM auth WHERE id = $1", reg.ID)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(stored))
+
+	// Unlock without the master key, factors in any order
+	db.Lock()
+	out, mko, err := db.MultiFactor(ctx, nil,
+		auth.KeyFileFactor(bytes.NewReader(keyFile)),
+		auth.PasswordFactor("testpassword"),
+		auth.PaperKeyFactor(paperKey))
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, reg.Factors, out.Factors)
+
+	db.Lock()
+	_, _, err = db.MultiFactor(ctx, nil,
+		auth.KeyFileFactor(bytes.NewReader(keyFile)),
+		auth.PasswordFactor("invalidpassword"),
+		auth.PaperKeyFactor(paperKey))
+	require.Equal(t, auth.ErrInvalidAuth, err)
+}
@@ -1,10 +1,41 @@
 package auth
 
-import "github.com/keys-pub/keys"
+import (
+	"time"
+
+	"github.com/keys-pub/keys"
+)
 
 // Options for auth.
 type Options struct {
 	ClientKey *keys.EdX25519Key
+	// MinPasswordStrength is the minimum strength for registered passwords.
+	MinPasswordStrength PasswordStrength
+	// KDFParams for new passwords, and the minimum for existing passwords,
+	// which are rehashed on unlock if below.
+	KDFParams *KDFParams
+	// PasswordWorkers is the maximum number of concurrent password key
+	// derivations (defaults to DefaultPasswordWorkers).
+	PasswordWorkers int
+	// Backoff for failed unlock attempts (see WithBackoff).
+	Backoff *Backoff
+	// Lockout after this many failed unlock attempts (0 to disable).
+	Lockout int
+	// SealedMetadata hides auth metadata (see WithSealedMetadata).
+	SealedMetadata bool
+	// Decoys is the minimum number of auth entries, padded with decoys (see
+	// WithDecoys).
+	Decoys int
+}
+
+// Backoff for failed unlock attempts.
+type Backoff struct {
+	// Free is the number of failed attempts allowed without delay.
+	Free int
+	// Delay after the first failure past the free attempts, doubled for each
+	// subsequent failure, up to Max. If 0, there is no delay.
+	Delay time.Duration
+	Max   time.Duration
 }
 
 // Option for DB.
@@ -24,3 +55,74 @@ func WithClientKey(key *keys.EdX25519Key) Option {
 		o.ClientKey = key
 	}
 }
+
+// WithMinPasswordStrength requires passwords to have a minimum (estimated)
+// strength for RegisterPassword.
+func WithMinPasswordStrength(min PasswordStrength) Option {
+	return func(o *Options) {
+		o.MinPasswordStrength = min
+	}
+}
+
+// WithKDFParams sets the password KDF parameters.
+// Existing passwords with weaker parameters are rehashed on unlock.
+func WithKDFParams(params KDFParams) Option {
+	return func(o *Options) {
+		o.KDFParams = &params
+	}
+}
+
+// WithPasswordWorkers sets the maximum number of password key derivations run
+// concurrently when authenticating with a password.
+// Each derivation uses the KDF memory (64MiB by default).
+func WithPasswordWorkers(n int) Option {
+	return func(o *Options) {
+		o.PasswordWorkers = n
+	}
+}
+
+// WithBackoff sets the delays enforced between failed unlock attempts.
+// Defaults to DefaultFreeAttempts, DefaultBackoff and DefaultMaxBackoff.
+func WithBackoff(free int, delay time.Duration, max time.Duration) Option {
+	return func(o *Options) {
+		o.Backoff = &Backoff{Free: free, Delay: delay, Max: max}
+	}
+}
+
+// WithLockout locks out an auth type after n failed unlock attempts, until an
+// unlock with a different auth type succeeds.
+func WithLockout(n int) Option {
+	return func(o *Options) {
+		o.Lockout = n
+	}
+}
+
+// WithSealedMetadata stores auth metadata (type, FIDO2 AAGUID and product,
+// key file fingerprint, token expiry, Shamir threshold, multi-factor factors,
+// names, usage and creation time) sealed with a key derived from the master
+// key, so only what's needed to attempt an unlock is visible in the auth db,
+// the same for each auth type (see sealAuth).
+// FIDO2 hmac-secret credentials (and type) are still visible, so we know which
+// auth to ask a device for.
+// Shamir share hashes aren't stored, so shares are only verified on unlock
+// (see ShamirRecovery.Add).
+// Existing auth methods are sealed on the next unlock, and sealed metadata is
+// only available after an unlock or register.
+// Passwords registered (or rehashed) with sealed metadata have salts the size
+// of other sealed auth salts.
+func WithSealedMetadata() Option {
+	return func(o *Options) {
+		o.SealedMetadata = true
+	}
+}
+
+// WithDecoys pads the auth db with decoy entries so there are at least n
+// entries, and the number of auth methods isn't revealed.
+// Decoys are only distinguishable with the master key, so this implies
+// WithSealedMetadata.
+func WithDecoys(n int) Option {
+	return func(o *Options) {
+		o.SealedMetadata = true
+		o.Decoys = n
+	}
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"time"
 
 	"github.com/getchill-app/keyring/auth/api"
@@ -9,6 +10,32 @@ import (
 	"github.com/pkg/errors"
 )
 
+// ErrPaperKeyMismatch if a re-entered paper key doesn't match.
+var ErrPaperKeyMismatch = errors.New("paper key doesn't match")
+
+// GeneratePaperKey generates a random paper key (a 24 word phrase).
+func GeneratePaperKey() string {
+	return keys.RandPhrase()
+}
+
+// ConfirmPaperKey checks a re-entered paper key matches (ignoring case and
+// spacing), otherwise returns ErrPaperKeyMismatch.
+func ConfirmPaperKey(paperKey string, confirm string) error {
+	key, err := encoding.PhraseToBytes(paperKey, true)
+	if err != nil {
+		return errors.Wrapf(err, "failed to decode paper key")
+	}
+	entered, err := encoding.PhraseToBytes(confirm, true)
+	if err != nil {
+		return ErrPaperKeyMismatch
+	}
+	if subtle.ConstantTimeCompare(key[:], entered[:]) != 1 {
+		return ErrPaperKeyMismatch
+	}
+	return nil
+}
+
+// NewPaperKey creates paper key auth.
 func NewPaperKey(paperKey string, mk *[32]byte) (*Auth, error) {
 	id := encoding.MustEncode(keys.RandBytes(32), encoding.Base62)
 
@@ -35,6 +62,9 @@ func (d *DB) RegisterPaperKey(paperKey string, mk *[32]byte) (*Auth, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := d.open(mk, nil); err != nil {
+		return nil, err
+	}
 
 	if err := d.Set(auth); err != nil {
 		return nil, err
@@ -44,7 +74,23 @@ func (d *DB) RegisterPaperKey(paperKey string, mk *[32]byte) (*Auth, error) {
 }
 
 // PaperKey authenticates using a paper key.
+// Failed attempts are limited, see WithBackoff and WithLockout; if limited,
+// returns ErrTooManyAttempts.
 func (d *DB) PaperKey(paperKey string) (*Auth, *[32]byte, error) {
+	return d.attempt(api.PaperKeyType, false, func() (*Auth, *[32]byte, error) {
+		return d.paperKey(paperKey)
+	})
+}
+
+// VerifyPaperKey checks a paper key, like PaperKey, to re-confirm it while
+// already unlocked (see attempt).
+func (d *DB) VerifyPaperKey(paperKey string) (*Auth, *[32]byte, error) {
+	return d.attempt(api.PaperKeyType, true, func() (*Auth, *[32]byte, error) {
+		return d.paperKey(paperKey)
+	})
+}
+
+func (d *DB) paperKey(paperKey string) (*Auth, *[32]byte, error) {
 	auths, err := d.ListByType(api.PaperKeyType)
 	if err != nil {
 		return nil, nil, errors.Wrapf(err, "failed to auth")
@@ -0,0 +1,9 @@
+package auth
+
+var SecretBoxSeal = secretBoxSeal
+
+var MatchAAGUID = matchAAGUID
+
+func (k *RecoveryKit) QRText() string {
+	return k.qrText()
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"sync"
 	"time"
 
 	"github.com/getchill-app/keyring/auth/api"
@@ -9,32 +10,61 @@ import (
 	"github.com/pkg/errors"
 )
 
+// NewPassword creates password auth with the default KDF parameters.
 func NewPassword(password string, mk *[32]byte) (*Auth, error) {
+	return NewPasswordWithKDF(password, mk, DefaultKDFParams)
+}
+
+// NewPasswordWithKDF creates password auth with KDF parameters.
+func NewPasswordWithKDF(password string, mk *[32]byte, params KDFParams) (*Auth, error) {
+	return newPassword(password, mk, params, keys.RandBytes(24))
+}
+
+// passwordSaltSize is the size of new password salts.
+// With sealed metadata, it's the size of other sealed auth salts (see
+// sealAuth), so it doesn't reveal the auth type.
+func (d *DB) passwordSaltSize() int {
+	if d.sealed {
+		return 32
+	}
+	return 24
+}
+
+func newPassword(password string, mk *[32]byte, params KDFParams, salt []byte) (*Auth, error) {
 	id := encoding.MustEncode(keys.RandBytes(32), encoding.Base62)
-	salt := keys.RandBytes(24)
-	key, err := keys.KeyForPassword(password, salt)
+	key, err := params.Key(password, salt)
 	if err != nil {
 		return nil, err
 	}
 	ek := secretBoxSeal(mk[:], key)
-	return &Auth{
+	auth := &Auth{
 		ID:           id,
 		Type:         api.PasswordType,
 		EncryptedKey: ek,
 		Salt:         salt,
 		CreatedAt:    time.Now(),
-	}, nil
+	}
+	setKDFParams(auth, params)
+	return auth, nil
 }
 
 // RegisterPassword registers a password.
+// If a minimum password strength is set (see WithMinPasswordStrength), returns
+// ErrWeakPassword if the password is too weak.
 func (d *DB) RegisterPassword(password string, mk *[32]byte) (*Auth, error) {
 	if mk == nil {
 		return nil, errors.Errorf("nil master key")
 	}
-	auth, err := NewPassword(password, mk)
+	if err := d.checkPasswordStrength(password); err != nil {
+		return nil, err
+	}
+	auth, err := newPassword(password, mk, d.kdf, keys.RandBytes(d.passwordSaltSize()))
 	if err != nil {
 		return nil, err
 	}
+	if err := d.open(mk, nil); err != nil {
+		return nil, err
+	}
 
 	if err := d.Set(auth); err != nil {
 		return nil, err
@@ -43,8 +73,53 @@ func (d *DB) RegisterPassword(password string, mk *[32]byte) (*Auth, error) {
 	return auth, nil
 }
 
+func (d *DB) checkPasswordStrength(password string) error {
+	if d.minPasswordStrength > VeryWeakPassword {
+		est := EstimatePasswordStrength(password)
+		if est.Strength < d.minPasswordStrength {
+			return ErrWeakPassword{Estimate: est, Min: d.minPasswordStrength}
+		}
+	}
+	return nil
+}
+
 // Password authenticates with a password.
+// Keys are derived for every password auth concurrently (see
+// WithPasswordWorkers), and all are checked whether or not one matches, so
+// the time taken doesn't depend on which auth (if any) matched.
+// If the password auth KDF parameters are weaker than the current parameters
+// (see WithKDFParams), the password is rehashed.
+// Failed attempts are limited, see WithBackoff and WithLockout; if limited,
+// returns ErrTooManyAttempts.
 func (d *DB) Password(password string) (*Auth, *[32]byte, error) {
+	auth, mk, err := d.attempt(api.PasswordType, false, func() (*Auth, *[32]byte, error) {
+		return d.password(password)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if kdfParams(auth).Weaker(d.kdf) && !isDuress(auth, mk) {
+		rehashed, err := d.rehashPassword(auth, password, mk)
+		if err != nil {
+			logger.Warningf("Failed to rehash password: %v", err)
+		} else {
+			auth = rehashed
+		}
+	}
+
+	return auth, mk, nil
+}
+
+// VerifyPassword checks a password, like Password, to re-confirm it while
+// already unlocked (see attempt), without rehashing it.
+func (d *DB) VerifyPassword(password string) (*Auth, *[32]byte, error) {
+	return d.attempt(api.PasswordType, true, func() (*Auth, *[32]byte, error) {
+		return d.password(password)
+	})
+}
+
+func (d *DB) password(password string) (*Auth, *[32]byte, error) {
 	if password == "" {
 		return nil, nil, ErrInvalidAuth
 	}
@@ -52,19 +127,68 @@ func (d *DB) Password(password string) (*Auth, *[32]byte, error) {
 	if err != nil {
 		return nil, nil, errors.Wrapf(err, "failed to auth")
 	}
-	for _, auth := range auths {
 
-		key, err := keys.KeyForPassword(password, auth.Salt)
-		if err != nil {
-			return nil, nil, errors.Wrapf(err, "failed to auth")
-		}
+	results := d.derivePasswords(password, auths)
 
-		mk := d.unlock(auth, key)
-		if mk == nil {
-			continue
+	var match *Auth
+	var mk *[32]byte
+	for i, res := range results {
+		if res.err != nil {
+			return nil, nil, errors.Wrapf(res.err, "failed to auth")
+		}
+		if res.mk != nil && match == nil {
+			match, mk = auths[i], res.mk
 		}
+	}
+	if match == nil {
+		return nil, nil, ErrInvalidAuth
+	}
+	return match, mk, nil
+}
+
+type passwordResult struct {
+	mk  *[32]byte
+	err error
+}
 
-		return auth, mk, nil
+// derivePasswords derives keys and opens the master key for each auth, with
+// at most d.passwordWorkers derivations at a time.
+func (d *DB) derivePasswords(password string, auths []*Auth) []passwordResult {
+	results := make([]passwordResult, len(auths))
+	sem := make(chan struct{}, d.passwordWorkers)
+	var wg sync.WaitGroup
+	for i, auth := range auths {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, auth *Auth) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			key, err := kdfParams(auth).Key(password, auth.Salt)
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			results[i].mk = d.unlock(auth, key)
+		}(i, auth)
+	}
+	wg.Wait()
+	return results
+}
+
+// rehashPassword updates password auth with the current KDF parameters.
+func (d *DB) rehashPassword(auth *Auth, password string, mk *[32]byte) (*Auth, error) {
+	logger.Debugf("Rehashing password %s", auth.ID)
+	salt := keys.RandBytes(d.passwordSaltSize())
+	key, err := d.kdf.Key(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	out := *auth
+	out.Salt = salt
+	out.EncryptedKey = secretBoxSeal(mk[:], key)
+	setKDFParams(&out, d.kdf)
+	if err := d.Set(&out); err != nil {
+		return nil, err
 	}
-	return nil, nil, ErrInvalidAuth
+	return &out, nil
 }
@@ -36,3 +36,31 @@ func TestPassword(t *testing.T) {
 	_, _, err = db.Password("")
 	require.EqualError(t, err, "invalid auth")
 }
+
+func TestPasswordMultiple(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		path := testutil.Path()
+		db, err := auth.NewDB(path, auth.WithPasswordWorkers(workers))
+		require.NoError(t, err)
+		defer func() { _ = os.Remove(path) }()
+
+		mk := testutil.Seed(0x01)
+		regs := []*auth.Auth{}
+		for _, password := range []string{"password1", "password2", "password3", "password4"} {
+			reg, err := db.RegisterPassword(password, mk)
+			require.NoError(t, err)
+			regs = append(regs, reg)
+		}
+
+		for i, password := range []string{"password1", "password2", "password3", "password4"} {
+			out, mko, err := db.Password(password)
+			require.NoError(t, err)
+			require.Equal(t, mk, mko)
+			require.Equal(t, regs[i].ID, out.ID)
+		}
+
+		_, _, err = db.Password("invalidpassword")
+		require.EqualError(t, err, "invalid auth")
+		require.NoError(t, db.Close())
+	}
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tyler-smith/go-bip39/wordlists"
+)
+
+const (
+	lowerChars  = "abcdefghijklmnopqrstuvwxyz"
+	upperChars  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars  = "0123456789"
+	symbolChars = "~!@#$%^&*()_+-={}[]|:;<>?,./"
+	// lookalikeChars are easily confused characters.
+	lookalikeChars = "0O1lI|"
+)
+
+// GenerateOptions for GeneratePassword.
+type GenerateOptions struct {
+	NoLower    bool
+	NoUpper    bool
+	NoDigits   bool
+	NoSymbols  bool
+	Lookalikes bool
+}
+
+// GenerateOption for GeneratePassword.
+type GenerateOption func(*GenerateOptions)
+
+func newGenerateOptions(opts ...GenerateOption) GenerateOptions {
+	var options GenerateOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	return options
+}
+
+// NoLower excludes lowercase characters (a-z).
+func NoLower() GenerateOption {
+	return func(o *GenerateOptions) {
+		o.NoLower = true
+	}
+}
+
+// NoUpper excludes uppercase characters (A-Z).
+func NoUpper() GenerateOption {
+	return func(o *GenerateOptions) {
+		o.NoUpper = true
+	}
+}
+
+// NoDigits excludes digits (0-9).
+func NoDigits() GenerateOption {
+	return func(o *GenerateOptions) {
+		o.NoDigits = true
+	}
+}
+
+// NoSymbols excludes symbols.
+func NoSymbols() GenerateOption {
+	return func(o *GenerateOptions) {
+		o.NoSymbols = true
+	}
+}
+
+// WithLookalikes includes easily confused characters (0, O, 1, l, I, |),
+// which are excluded by default.
+func WithLookalikes() GenerateOption {
+	return func(o *GenerateOptions) {
+		o.Lookalikes = true
+	}
+}
+
+// GeneratePassword generates a random password of length.
+// The password includes at least one character from each character class
+// that isn't excluded.
+func GeneratePassword(length int, opt ...GenerateOption) (string, error) {
+	opts := newGenerateOptions(opt...)
+	classes := []string{}
+	if !opts.NoLower {
+		classes = append(classes, lowerChars)
+	}
+	if !opts.NoUpper {
+		classes = append(classes, upperChars)
+	}
+	if !opts.NoDigits {
+		classes = append(classes, digitChars)
+	}
+	if !opts.NoSymbols {
+		classes = append(classes, symbolChars)
+	}
+	if !opts.Lookalikes {
+		for i, class := range classes {
+			classes[i] = removeChars(class, lookalikeChars)
+		}
+	}
+	if len(classes) == 0 {
+		return "", errors.Errorf("no character classes")
+	}
+	if length < len(classes) {
+		return "", errors.Errorf("password length must be at least %d", len(classes))
+	}
+	all := strings.Join(classes, "")
+
+	b := make([]byte, 0, length)
+	// One from each class, then the rest from all classes, then shuffle.
+	for _, class := range classes {
+		b = append(b, class[randInt(len(class))])
+	}
+	for len(b) < length {
+		b = append(b, all[randInt(len(all))])
+	}
+	for i := len(b) - 1; i > 0; i-- {
+		j := randInt(i + 1)
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b), nil
+}
+
+// GeneratePassphrase generates a random (diceware-style) passphrase with
+// words from the BIP39 English word list (11 bits of entropy per word).
+func GeneratePassphrase(words int, separator string) (string, error) {
+	if words <= 0 {
+		return "", errors.Errorf("invalid number of words")
+	}
+	out := make([]string, 0, words)
+	for i := 0; i < words; i++ {
+		out = append(out, wordlists.English[randInt(len(wordlists.English))])
+	}
+	return strings.Join(out, separator), nil
+}
+
+func removeChars(s string, chars string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(chars, r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func randInt(max int) int {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		panic(err)
+	}
+	return int(n.Int64())
+}
@@ -0,0 +1,46 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	pw, err := auth.GeneratePassword(16)
+	require.NoError(t, err)
+	require.Equal(t, 16, len(pw))
+	require.True(t, strings.ContainsAny(pw, "abcdefghijkmnopqrstuvwxyz"))
+	require.True(t, strings.ContainsAny(pw, "ABCDEFGHJKLMNPQRSTUVWXYZ"))
+	require.True(t, strings.ContainsAny(pw, "23456789"))
+	require.False(t, strings.ContainsAny(pw, "0O1lI|"))
+
+	for i := 0; i < 100; i++ {
+		pw, err = auth.GeneratePassword(8, auth.NoSymbols(), auth.NoUpper(), auth.NoLower())
+		require.NoError(t, err)
+		require.Equal(t, "", strings.Trim(pw, "23456789"))
+	}
+
+	pw, err = auth.GeneratePassword(4, auth.WithLookalikes())
+	require.NoError(t, err)
+	require.Equal(t, 4, len(pw))
+
+	_, err = auth.GeneratePassword(3)
+	require.EqualError(t, err, "password length must be at least 4")
+	_, err = auth.GeneratePassword(8, auth.NoSymbols(), auth.NoUpper(), auth.NoLower(), auth.NoDigits())
+	require.EqualError(t, err, "no character classes")
+}
+
+func TestGeneratePassphrase(t *testing.T) {
+	pp, err := auth.GeneratePassphrase(6, "-")
+	require.NoError(t, err)
+	require.Equal(t, 6, len(strings.Split(pp, "-")))
+
+	est := auth.EstimatePasswordStrength(pp)
+	require.Equal(t, auth.StrongPassword, est.Strength)
+
+	_, err = auth.GeneratePassphrase(0, " ")
+	require.EqualError(t, err, "invalid number of words")
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/tyler-smith/go-bip39/wordlists"
+)
+
+// PasswordStrength is an estimated password strength.
+type PasswordStrength int
+
+// Password strengths.
+const (
+	VeryWeakPassword   PasswordStrength = 0
+	WeakPassword       PasswordStrength = 1
+	FairPassword       PasswordStrength = 2
+	StrongPassword     PasswordStrength = 3
+	VeryStrongPassword PasswordStrength = 4
+)
+
+func (s PasswordStrength) String() string {
+	switch s {
+	case VeryWeakPassword:
+		return "very weak"
+	case WeakPassword:
+		return "weak"
+	case FairPassword:
+		return "fair"
+	case StrongPassword:
+		return "strong"
+	case VeryStrongPassword:
+		return "very strong"
+	default:
+		return fmt.Sprintf("strength(%d)", int(s))
+	}
+}
+
+// PasswordEstimate is the result of EstimatePasswordStrength.
+type PasswordEstimate struct {
+	// Entropy is the estimated entropy in bits.
+	Entropy  float64
+	Strength PasswordStrength
+	// Warnings describe why a password is weaker than its length suggests.
+	Warnings []string
+}
+
+// ErrWeakPassword if a password doesn't meet the minimum strength.
+type ErrWeakPassword struct {
+	Estimate *PasswordEstimate
+	Min      PasswordStrength
+}
+
+func (e ErrWeakPassword) Error() string {
+	msg := fmt.Sprintf("password is too weak (%s, requires %s)", e.Estimate.Strength, e.Min)
+	if len(e.Estimate.Warnings) > 0 {
+		msg += ": " + strings.Join(e.Estimate.Warnings, ", ")
+	}
+	return msg
+}
+
+// commonPasswords are some of the most common passwords (lowercase).
+var commonPasswords = map[string]bool{
+	"password": true, "passw0rd": true, "123456": true, "12345678": true,
+	"123456789": true, "1234567890": true, "qwerty": true, "qwertyuiop": true,
+	"abc123": true, "111111": true, "letmein": true, "welcome": true,
+	"iloveyou": true, "admin": true, "monkey": true, "dragon": true,
+	"sunshine": true, "princess": true, "football": true, "baseball": true,
+	"master": true, "shadow": true, "trustno1": true, "superman": true,
+	"secret": true, "login": true, "starwars": true, "whatever": true,
+	"changeme": true, "default": true,
+}
+
+var wordIndex = func() map[string]bool {
+	m := make(map[string]bool, len(wordlists.English))
+	for _, w := range wordlists.English {
+		m[w] = true
+	}
+	return m
+}()
+
+// sequences used to detect runs like "abcd", "1234" or "qwer".
+var sequences = []string{
+	lowerChars,
+	digitChars,
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+}
+
+// EstimatePasswordStrength estimates the strength of a password.
+// The estimate is conservative for patterns it recognizes (common passwords,
+// words, repeats and sequences), and otherwise assumes characters are chosen
+// at random from the character classes used.
+func EstimatePasswordStrength(password string) *PasswordEstimate {
+	est := &PasswordEstimate{}
+	if password == "" {
+		est.Warnings = append(est.Warnings, "empty")
+		return est
+	}
+	lower := strings.ToLower(password)
+
+	// Passphrase of dictionary words
+	if words := strings.FieldsFunc(lower, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '-' || r == '.' || r == '_'
+	}); len(words) > 1 && allWords(words) {
+		est.Entropy = float64(len(words)) * math.Log2(float64(len(wordlists.English)))
+		if len(words) < 4 {
+			est.Warnings = append(est.Warnings, "too few words")
+		}
+		est.Strength = strengthForEntropy(est.Entropy)
+		return est
+	}
+
+	if commonPasswords[strings.TrimRight(lower, digitChars+symbolChars)] {
+		est.Warnings = append(est.Warnings, "common password")
+		est.Entropy = math.Min(10, float64(len(password)))
+		est.Strength = VeryWeakPassword
+		return est
+	}
+	if wordIndex[lower] {
+		est.Warnings = append(est.Warnings, "dictionary word")
+		est.Entropy = math.Log2(float64(len(wordlists.English)))
+		est.Strength = strengthForEntropy(est.Entropy)
+		return est
+	}
+
+	pool, classes := 0, 0
+	var hasLower, hasUpper, hasDigit, hasOther bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasOther = true
+		}
+	}
+	for _, c := range []struct {
+		has  bool
+		size int
+	}{{hasLower, 26}, {hasUpper, 26}, {hasDigit, 10}, {hasOther, 33}} {
+		if c.has {
+			pool += c.size
+			classes++
+		}
+	}
+
+	length, repeats, seqs := effectiveLength(lower)
+	est.Entropy = length * math.Log2(float64(pool))
+
+	runes := len([]rune(password))
+	if runes < 8 {
+		est.Warnings = append(est.Warnings, "too short")
+	}
+	if classes == 1 && runes < 16 {
+		est.Warnings = append(est.Warnings, "only one character class")
+	}
+	if repeats {
+		est.Warnings = append(est.Warnings, "repeated characters")
+	}
+	if seqs {
+		est.Warnings = append(est.Warnings, "sequences")
+	}
+	est.Strength = strengthForEntropy(est.Entropy)
+	return est
+}
+
+func strengthForEntropy(entropy float64) PasswordStrength {
+	switch {
+	case entropy < 28:
+		return VeryWeakPassword
+	case entropy < 36:
+		return WeakPassword
+	case entropy < 60:
+		return FairPassword
+	case entropy < 80:
+		return StrongPassword
+	default:
+		return VeryStrongPassword
+	}
+}
+
+func allWords(words []string) bool {
+	for _, w := range words {
+		if !wordIndex[w] {
+			return false
+		}
+	}
+	return true
+}
+
+// effectiveLength returns the length of a password, counting characters in
+// repeats or sequences as a quarter of a character.
+func effectiveLength(s string) (float64, bool, bool) {
+	runes := []rune(s)
+	length := 0.0
+	repeats, seqs := false, false
+	for i := range runes {
+		if i >= 2 && runes[i] == runes[i-1] && runes[i] == runes[i-2] {
+			repeats = true
+			length += 0.25
+			continue
+		}
+		if i >= 2 && inSequence(runes[i-2:i+1]) {
+			seqs = true
+			length += 0.25
+			continue
+		}
+		length++
+	}
+	return length, repeats, seqs
+}
+
+func inSequence(rs []rune) bool {
+	s := string(rs)
+	for _, seq := range sequences {
+		if strings.Contains(seq, s) || strings.Contains(reverse(seq), s) {
+			return true
+		}
+	}
+	return false
+}
+
+func reverse(s string) string {
+	rs := []rune(s)
+	for i, j := 0, len(rs)-1; i < j; i, j = i+1, j-1 {
+		rs[i], rs[j] = rs[j], rs[i]
+	}
+	return string(rs)
+}
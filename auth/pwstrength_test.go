@@ -0,0 +1,57 @@
+package auth_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimatePasswordStrength(t *testing.T) {
+	cases := []struct {
+		password string
+		strength auth.PasswordStrength
+		warnings []string
+	}{
+		{"", auth.VeryWeakPassword, []string{"empty"}},
+		{"password", auth.VeryWeakPassword, []string{"common password"}},
+		{"Password123!", auth.VeryWeakPassword, []string{"common password"}},
+		{"abandon", auth.VeryWeakPassword, []string{"dictionary word"}},
+		{"aaaaaaaaaaaa", auth.VeryWeakPassword, []string{"only one character class", "repeated characters"}},
+		{"abcdefgh1234", auth.WeakPassword, []string{"sequences"}},
+		{"x7#Kp", auth.WeakPassword, []string{"too short"}},
+		{"hT9qmz2w", auth.FairPassword, nil},
+		{"hT9q#z2w!Lp4", auth.StrongPassword, nil},
+		{"hT9q#z2w!Lp4Vb8$", auth.VeryStrongPassword, nil},
+		{"abandon ability", auth.VeryWeakPassword, []string{"too few words"}},
+		{"abandon ability able about", auth.FairPassword, nil},
+		{"abandon ability able about above absent absorb", auth.StrongPassword, nil},
+	}
+	for _, c := range cases {
+		est := auth.EstimatePasswordStrength(c.password)
+		require.Equal(t, c.strength, est.Strength, c.password)
+		require.Equal(t, c.warnings, est.Warnings, c.password)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path, auth.WithMinPasswordStrength(auth.StrongPassword))
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+
+	mk := testutil.Seed(0x01)
+
+	_, err = db.RegisterPassword("password123", mk)
+	require.EqualError(t, err, "password is too weak (very weak, requires strong): common password")
+	var weak auth.ErrWeakPassword
+	require.ErrorAs(t, err, &weak)
+
+	_, err = db.RegisterPassword("hT9qmz2w", mk)
+	require.EqualError(t, err, "password is too weak (fair, requires strong)")
+
+	_, err = db.RegisterPassword("hT9q#z2w!Lp4", mk)
+	require.NoError(t, err)
+}
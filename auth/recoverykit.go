@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/keys-pub/keys"
+	"github.com/pkg/errors"
+	"rsc.io/qr"
+)
+
+// RecoveryKit is a printable sheet with a generated paper key.
+// The paper key is registered (see RegisterRecoveryKit) only after the user
+// re-enters it, so we know it was written down; then the kit (with the
+// registration date) can be printed.
+type RecoveryKit struct {
+	PaperKey string
+	VaultID  keys.ID
+	// RegisteredAt is set by RegisterRecoveryKit.
+	RegisteredAt time.Time
+}
+
+// VaultID identifies the vault (it's the client key ID).
+func (d *DB) VaultID() keys.ID {
+	return d.ck.ID
+}
+
+// NewRecoveryKit creates a recovery kit with a generated paper key.
+func (d *DB) NewRecoveryKit() *RecoveryKit {
+	return &RecoveryKit{
+		PaperKey: GeneratePaperKey(),
+		VaultID:  d.VaultID(),
+	}
+}
+
+// RegisterRecoveryKit registers the recovery kit paper key, if confirm (the
+// re-entered paper key) matches, otherwise returns ErrPaperKeyMismatch.
+// On success, the kit registration date is set.
+func (d *DB) RegisterRecoveryKit(kit *RecoveryKit, confirm string, mk *[32]byte) (*Auth, error) {
+	if kit == nil {
+		return nil, errors.Errorf("nil recovery kit")
+	}
+	if kit.VaultID != d.VaultID() {
+		return nil, errors.Errorf("recovery kit is for a different vault")
+	}
+	if err := ConfirmPaperKey(kit.PaperKey, confirm); err != nil {
+		return nil, err
+	}
+	auth, err := d.RegisterPaperKey(kit.PaperKey, mk)
+	if err != nil {
+		return nil, err
+	}
+	kit.RegisteredAt = d.clock.Now()
+	return auth, nil
+}
+
+// Text returns the recovery kit as a printable text sheet.
+func (k *RecoveryKit) Text() string {
+	var sb strings.Builder
+	sb.WriteString("Recovery Kit\n\n")
+	fmt.Fprintf(&sb, "Vault: %s\n", k.VaultID)
+	if !k.RegisteredAt.IsZero() {
+		fmt.Fprintf(&sb, "Registered: %s\n", k.RegisteredAt.Format("2006-01-02"))
+	}
+	sb.WriteString("\n")
+	sb.WriteString("Paper Key:\n\n")
+	words := strings.Fields(k.PaperKey)
+	for i := 0; i < len(words); i += 4 {
+		cols := []string{}
+		for j := i; j < i+4 && j < len(words); j++ {
+			cols = append(cols, fmt.Sprintf("%2d. %-10s", j+1, words[j]))
+		}
+		sb.WriteString(strings.TrimRight(strings.Join(cols, " "), " ") + "\n")
+	}
+	sb.WriteString("\nAnyone with this paper key can unlock the vault.\n")
+	sb.WriteString("Keep it somewhere safe, and don't store it on this computer.\n")
+	return sb.String()
+}
+
+// QRCode returns a QR code (PNG) of the paper key, vault identifier and
+// registration date (see qrText).
+func (k *RecoveryKit) QRCode() ([]byte, error) {
+	code, err := qr.Encode(k.qrText(), qr.M)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode qr code")
+	}
+	return code.PNG(), nil
+}
+
+// qrText is the QR code content, a URI with the paper key, vault identifier
+// and registration date (recovery-kit:?key=...&registered=...&vault=...).
+func (k *RecoveryKit) qrText() string {
+	values := url.Values{}
+	values.Set("key", k.PaperKey)
+	values.Set("vault", k.VaultID.String())
+	if !k.RegisteredAt.IsZero() {
+		values.Set("registered", k.RegisteredAt.Format("2006-01-02"))
+	}
+	return "recovery-kit:?" + values.Encode()
+}
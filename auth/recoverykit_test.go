@@ -0,0 +1,77 @@
+package auth_test
+
+import (
+	"bytes"
+	"image/png"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/encoding"
+	"github.com/keys-pub/keys/tsutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratePaperKey(t *testing.T) {
+	paperKey := auth.GeneratePaperKey()
+	require.Equal(t, 24, len(strings.Fields(paperKey)))
+	_, err := encoding.PhraseToBytes(paperKey, true)
+	require.NoError(t, err)
+
+	err = auth.ConfirmPaperKey(paperKey, "  "+strings.ToUpper(paperKey)+"\n")
+	require.NoError(t, err)
+	err = auth.ConfirmPaperKey(paperKey, keys.RandPhrase())
+	require.Equal(t, auth.ErrPaperKeyMismatch, err)
+	err = auth.ConfirmPaperKey(paperKey, "")
+	require.Equal(t, auth.ErrPaperKeyMismatch, err)
+}
+
+func TestRecoveryKit(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+	db.SetClock(tsutil.NewTestClock())
+
+	mk := testutil.Seed(0x01)
+	kit := db.NewRecoveryKit()
+	require.Equal(t, db.VaultID(), kit.VaultID)
+
+	_, err = db.RegisterRecoveryKit(kit, keys.RandPhrase(), mk)
+	require.Equal(t, auth.ErrPaperKeyMismatch, err)
+	require.True(t, kit.RegisteredAt.IsZero())
+	auths, err := db.List()
+	require.NoError(t, err)
+	require.Equal(t, 0, len(auths))
+
+	reg, err := db.RegisterRecoveryKit(kit, kit.PaperKey, mk)
+	require.NoError(t, err)
+	require.False(t, kit.RegisteredAt.IsZero())
+
+	text := kit.Text()
+	require.Contains(t, text, "Vault: "+kit.VaultID.String()+"\n")
+	require.Contains(t, text, "Registered: 2009-02-13\n")
+	words := strings.Fields(kit.PaperKey)
+	require.Contains(t, text, " 1. "+words[0])
+	require.Contains(t, text, "24. "+words[23]+"\n")
+
+	u, err := url.Parse(kit.QRText())
+	require.NoError(t, err)
+	require.Equal(t, "recovery-kit", u.Scheme)
+	require.Equal(t, kit.PaperKey, u.Query().Get("key"))
+	require.Equal(t, kit.VaultID.String(), u.Query().Get("vault"))
+	require.Equal(t, "2009-02-13", u.Query().Get("registered"))
+	b, err := kit.QRCode()
+	require.NoError(t, err)
+	_, err = png.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+
+	out, mko, err := db.PaperKey(kit.PaperKey)
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, reg.ID, out.ID)
+}
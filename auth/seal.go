@@ -0,0 +1,415 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/encoding"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v4"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// metaSize is the size sealed metadata is padded to, so it doesn't reveal the
+// auth type.
+const metaSize = 512
+
+// secretBoxKeySize is the size of a master key encrypted with secretbox.
+const secretBoxKeySize = 24 + secretbox.Overhead + 32
+
+// sealedKeySize is the size encrypted keys are padded to with sealed metadata,
+// the size of a master key encrypted to an X25519 key (see NewX25519), so it
+// doesn't reveal the auth type.
+const sealedKeySize = 32 + box.Overhead + 32
+
+// authMeta is auth metadata sealed in the meta column (see
+// WithSealedMetadata).
+type authMeta struct {
+	Type       Type      `msgpack:"type,omitempty"`
+	AAGUID     string    `msgpack:"aaguid,omitempty"`
+	NoPin      bool      `msgpack:"nopin,omitempty"`
+	KID        keys.ID   `msgpack:"kid,omitempty"`
+	KDF        string    `msgpack:"kdf,omitempty"`
+	KDFTime    uint32    `msgpack:"kdfTime,omitempty"`
+	KDFMemory  uint32    `msgpack:"kdfMemory,omitempty"`
+	KDFThreads uint8     `msgpack:"kdfThreads,omitempty"`
+	Name       string    `msgpack:"name,omitempty"`
+	Product    string    `msgpack:"product,omitempty"`
+	CreatedAt  time.Time `msgpack:"createdAt,omitempty"`
+	LastUsedAt int64     `msgpack:"lastUsedAt,omitempty"`
+	Uses       int       `msgpack:"uses,omitempty"`
+	// Fingerprint of a key file.
+	Fingerprint []byte `msgpack:"fingerprint,omitempty"`
+	// ExpiresAt of a token.
+	ExpiresAt int64 `msgpack:"expiresAt,omitempty"`
+	// Threshold of Shamir secret sharing. Share hashes aren't kept (they
+	// don't fit, see ShamirRecovery).
+	Threshold int `msgpack:"threshold,omitempty"`
+	// Factors are multi-factor auth factors, without salts or credential IDs
+	// (see sealFactors).
+	Factors api.Factors `msgpack:"factors,omitempty"`
+	// Decoy if the auth is padding (see WithDecoys).
+	Decoy bool `msgpack:"decoy,omitempty"`
+}
+
+func metaKey(mk *[32]byte) *[32]byte {
+	return keys.Bytes32(keys.HKDFSHA256(mk[:], 32, nil, []byte("keyring/auth/meta")))
+}
+
+// sealAuth returns the auth as stored with sealed metadata.
+// Only what's needed to attempt an unlock is left visible: the ID (FIDO2
+// credential ID), encrypted key (padded to sealedKeySize), salt and KDF
+// parameters, which every sealed auth has (params, unless it's a password).
+// The type is hidden, except for FIDO2 hmac-secret, which is needed to know
+// which auth to ask a device for, and so are FIDO2 hmac-secret factors of
+// multi-factor auth (see sealFactors).
+func sealAuth(auth *Auth, key *[32]byte, params KDFParams) (*Auth, error) {
+	if key == nil {
+		return nil, ErrLocked
+	}
+	factors, factorsMeta := sealFactors(auth.Factors, auth.Salt)
+	meta := &authMeta{
+		Type:        auth.Type,
+		AAGUID:      auth.AAGUID,
+		NoPin:       auth.NoPin,
+		KID:         auth.KID,
+		KDF:         auth.KDF,
+		KDFTime:     auth.KDFTime,
+		KDFMemory:   auth.KDFMemory,
+		KDFThreads:  auth.KDFThreads,
+		Name:        auth.Name,
+		Product:     auth.Product,
+		CreatedAt:   auth.CreatedAt,
+		LastUsedAt:  auth.LastUsedAt,
+		Uses:        auth.Uses,
+		Fingerprint: auth.Fingerprint,
+		ExpiresAt:   auth.ExpiresAt,
+		Threshold:   auth.Threshold,
+		Factors:     factorsMeta,
+	}
+	sealed, err := sealMeta(meta, key)
+	if err != nil {
+		return nil, err
+	}
+	row := &Auth{
+		ID:           auth.ID,
+		EncryptedKey: padKey(auth.EncryptedKey),
+		Salt:         auth.Salt,
+		Factors:      factors,
+		Meta:         sealed,
+	}
+	if auth.Type == api.FIDO2HMACSecretType {
+		row.Type = auth.Type
+	}
+	if len(row.Salt) == 0 {
+		// Paper keys, X25519 and tokens don't have a salt
+		row.Salt = keys.RandBytes(32)
+	}
+	switch auth.Type {
+	case api.PasswordType:
+		params = kdfParams(auth)
+	case api.MultiFactorType:
+		for _, f := range auth.Factors {
+			if f.Type == api.PasswordType && f.KDF != "" {
+				params = KDFParams{Algorithm: f.KDF, Time: f.KDFTime, Memory: f.KDFMemory, Threads: f.KDFThreads}
+				break
+			}
+		}
+	}
+	setKDFParams(row, params)
+	return row, nil
+}
+
+// padKey pads an encrypted key to sealedKeySize with random bytes.
+func padKey(ek []byte) []byte {
+	if len(ek) >= sealedKeySize {
+		return ek
+	}
+	return append(append([]byte{}, ek...), keys.RandBytes(sealedKeySize-len(ek))...)
+}
+
+// unsealAuth returns the auth with sealed metadata restored, and whether it's
+// a decoy.
+// If the auth isn't sealed, it's returned as is.
+func unsealAuth(row *Auth, key *[32]byte) (*Auth, bool, error) {
+	if len(row.Meta) == 0 {
+		return row, false, nil
+	}
+	if key == nil {
+		return nil, false, ErrLocked
+	}
+	meta, err := openMeta(row.Meta, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if meta.Decoy {
+		return nil, true, nil
+	}
+	auth := &Auth{
+		ID:           row.ID,
+		EncryptedKey: row.EncryptedKey,
+		Salt:         row.Salt,
+		Fingerprint:  meta.Fingerprint,
+		ExpiresAt:    meta.ExpiresAt,
+		Factors:      unsealFactors(row.Factors, meta.Factors, row.Salt),
+		Threshold:    meta.Threshold,
+		Type:         meta.Type,
+		AAGUID:       meta.AAGUID,
+		NoPin:        meta.NoPin,
+		KID:          meta.KID,
+		KDF:          meta.KDF,
+		KDFTime:      meta.KDFTime,
+		KDFMemory:    meta.KDFMemory,
+		KDFThreads:   meta.KDFThreads,
+		Name:         meta.Name,
+		Product:      meta.Product,
+		CreatedAt:    meta.CreatedAt,
+		LastUsedAt:   meta.LastUsedAt,
+		Uses:         meta.Uses,
+	}
+	if auth.Type == api.PaperKeyType || auth.Type == api.X25519Type || auth.Type == api.TokenType {
+		auth.Salt = nil
+	}
+	if auth.Type != api.X25519Type && len(auth.EncryptedKey) > secretBoxKeySize {
+		auth.EncryptedKey = auth.EncryptedKey[:secretBoxKeySize]
+	}
+	return auth, false, nil
+}
+
+// sealFactors splits multi-factor auth factors into what's stored (what's
+// needed to derive a key) and what's sealed (type, AAGUID and KDF parameters).
+// Password and key file factor salts are derived from the auth salt (see
+// factorSalt), so only FIDO2 hmac-secret factors (salt, credential ID, which
+// is needed to ask a device, and no PIN) are stored.
+// Factors with salts that aren't derived (registered by an earlier version)
+// are all stored, paper keys with a random salt, so they look like the others.
+func sealFactors(factors api.Factors, salt []byte) (api.Factors, api.Factors) {
+	if len(factors) == 0 {
+		return nil, nil
+	}
+	derived := true
+	for i, f := range factors {
+		if f.Type != api.FIDO2HMACSecretType && len(f.Salt) > 0 && !bytes.Equal(f.Salt, factorSalt(salt, i)) {
+			derived = false
+		}
+	}
+	stored := make(api.Factors, 0, len(factors))
+	sealed := make(api.Factors, 0, len(factors))
+	for _, f := range factors {
+		if f.Type == api.FIDO2HMACSecretType || !derived {
+			s := &api.Factor{Salt: f.Salt, CredentialID: f.CredentialID, NoPin: f.NoPin}
+			if len(s.Salt) == 0 {
+				s.Salt = keys.RandBytes(32)
+			}
+			params := KDFParams{Algorithm: f.KDF, Time: f.KDFTime, Memory: f.KDFMemory, Threads: f.KDFThreads}
+			if f.KDF != "" && params != DefaultKDFParams {
+				s.KDF, s.KDFTime, s.KDFMemory, s.KDFThreads = f.KDF, f.KDFTime, f.KDFMemory, f.KDFThreads
+			}
+			stored = append(stored, s)
+		}
+		sealed = append(sealed, &api.Factor{
+			Type:       f.Type,
+			AAGUID:     f.AAGUID,
+			KDF:        f.KDF,
+			KDFTime:    f.KDFTime,
+			KDFMemory:  f.KDFMemory,
+			KDFThreads: f.KDFThreads,
+		})
+	}
+	if len(stored) == 0 {
+		return nil, sealed
+	}
+	return stored, sealed
+}
+
+// unsealFactors restores factors split by sealFactors.
+func unsealFactors(stored api.Factors, sealed api.Factors, salt []byte) api.Factors {
+	if len(sealed) == 0 {
+		return stored
+	}
+	factors := make(api.Factors, 0, len(sealed))
+	next := 0
+	for i, sf := range sealed {
+		f := &api.Factor{
+			Type:       sf.Type,
+			AAGUID:     sf.AAGUID,
+			KDF:        sf.KDF,
+			KDFTime:    sf.KDFTime,
+			KDFMemory:  sf.KDFMemory,
+			KDFThreads: sf.KDFThreads,
+		}
+		if len(stored) == len(sealed) || f.Type == api.FIDO2HMACSecretType {
+			if next >= len(stored) {
+				return stored
+			}
+			s := stored[next]
+			next++
+			f.Salt, f.CredentialID, f.NoPin = s.Salt, s.CredentialID, s.NoPin
+		} else {
+			f.Salt = factorSalt(salt, i)
+		}
+		if f.Type == api.PaperKeyType {
+			f.Salt = nil
+		}
+		factors = append(factors, f)
+	}
+	return factors
+}
+
+// newDecoy creates a decoy auth (see WithDecoys), which looks like other
+// sealed auth.
+func newDecoy(key *[32]byte, params KDFParams) (*Auth, error) {
+	sealed, err := sealMeta(&authMeta{Decoy: true}, key)
+	if err != nil {
+		return nil, err
+	}
+	decoy := &Auth{
+		ID:           encoding.MustEncode(keys.RandBytes(32), encoding.Base62),
+		EncryptedKey: keys.RandBytes(sealedKeySize),
+		Salt:         keys.RandBytes(32),
+		Meta:         sealed,
+	}
+	setKDFParams(decoy, params)
+	return decoy, nil
+}
+
+func sealMeta(meta *authMeta, key *[32]byte) ([]byte, error) {
+	b, err := msgpack.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > metaSize-2 {
+		return nil, errors.Errorf("auth metadata too large")
+	}
+	padded := make([]byte, metaSize)
+	binary.BigEndian.PutUint16(padded, uint16(len(b)))
+	copy(padded[2:], b)
+	return secretBoxSeal(padded, key), nil
+}
+
+func openMeta(sealed []byte, key *[32]byte) (*authMeta, error) {
+	padded, ok := secretBoxOpen(sealed, key)
+	if !ok || len(padded) < 2 {
+		return nil, errors.Errorf("invalid auth metadata")
+	}
+	n := int(binary.BigEndian.Uint16(padded))
+	if n > len(padded)-2 {
+		return nil, errors.Errorf("invalid auth metadata")
+	}
+	var meta authMeta
+	if err := msgpack.Unmarshal(padded[2:2+n], &meta); err != nil {
+		return nil, errors.Errorf("invalid auth metadata")
+	}
+	return &meta, nil
+}
+
+// storedAuth returns auth as it should be stored, sealed if
+// WithSealedMetadata.
+func (d *DB) storedAuth(auth *Auth) (*Auth, error) {
+	if d.sealed {
+		return sealAuth(auth, d.metaKey, d.kdf)
+	}
+	row := *auth
+	row.Meta = nil
+	return &row, nil
+}
+
+// unsealAuths returns auths with sealed metadata restored, without decoys.
+// Auth with metadata we can't open (which wasn't sealed by us) are skipped.
+func (d *DB) unsealAuths(rows []*Auth) ([]*Auth, error) {
+	auths := make([]*Auth, 0, len(rows))
+	for _, row := range rows {
+		auth, decoy, err := unsealAuth(row, d.metaKey)
+		if err != nil {
+			if errors.Is(err, ErrLocked) {
+				return nil, err
+			}
+			logger.Warningf("Skipping auth %s: %v", row.ID, err)
+			continue
+		}
+		if decoy {
+			continue
+		}
+		auths = append(auths, auth)
+	}
+	return auths, nil
+}
+
+// migrateSealed re-stores auth methods sealed or unsealed, and adds or
+// removes decoys, to match the options (WithSealedMetadata, WithDecoys).
+func (d *DB) migrateSealed() error {
+	rows, err := d.list()
+	if err != nil {
+		return err
+	}
+	return Transact(d.db, func(tx *sqlx.Tx) error {
+		for _, row := range rows {
+			auth, decoy, err := unsealAuth(row, d.metaKey)
+			if err != nil {
+				logger.Warningf("Skipping auth %s: %v", row.ID, err)
+				continue
+			}
+			if decoy {
+				if !d.sealed {
+					if err := d.deleteAuthTx(tx, row.ID); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if d.sealed == (len(row.Meta) > 0) {
+				continue
+			}
+			logger.Debugf("Migrating auth %s (sealed=%t)", auth.ID, d.sealed)
+			if err := d.setAuthTx(tx, auth); err != nil {
+				return err
+			}
+		}
+		return d.padTx(tx)
+	})
+}
+
+// padTx adds or removes decoys so there are at least d.decoys auth methods.
+func (d *DB) padTx(tx *sqlx.Tx) error {
+	if !d.sealed || d.metaKey == nil {
+		return nil
+	}
+	var rows []*Auth
+	if err := tx.Select(&rows, "SELECT * FROM auth"); err != nil {
+		return err
+	}
+	decoys := []string{}
+	for _, row := range rows {
+		if len(row.Meta) == 0 {
+			continue
+		}
+		meta, err := openMeta(row.Meta, d.metaKey)
+		if err != nil {
+			continue
+		}
+		if meta.Decoy {
+			decoys = append(decoys, row.ID)
+		}
+	}
+	for n := len(rows); n > d.decoys && len(decoys) > 0; n-- {
+		if err := d.deleteAuthTx(tx, decoys[0]); err != nil {
+			return err
+		}
+		decoys = decoys[1:]
+	}
+	for n := len(rows); n < d.decoys; n++ {
+		decoy, err := newDecoy(d.metaKey, d.kdf)
+		if err != nil {
+			return err
+		}
+		if err := d.setRowTx(tx, decoy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/tsutil"
+	"github.com/stretchr/testify/require"
+)
+
+type authRow struct {
+	Type   string `db:"type"`
+	AAGUID string `db:"aaguid"`
+	Name   string `db:"name"`
+	Salt   []byte `db:"salt"`
+	EK     []byte `db:"ek"`
+	Meta   []byte `db:"meta"`
+}
+
+func authRows(t *testing.T, path string) []*authRow {
+	sdb, err := sqlx.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer sdb.Close()
+	var rows []*authRow
+	err = sdb.Select(&rows, "SELECT type, aaguid, name, salt, ek, meta FROM auth")
+	require.NoError(t, err)
+	return rows
+}
+
+func TestSealedMetadata(t *testing.T) {
+	path := testutil.Path()
+	defer func() { _ = os.Remove(path) }()
+
+	db, err := auth.NewDB(path, auth.WithDecoys(5))
+	require.NoError(t, err)
+
+	mk := testutil.Seed(0x01)
+	paperKey := keys.RandPhrase()
+	pk, err := db.RegisterPaperKey(paperKey, mk)
+	require.NoError(t, err)
+	pw, err := db.RegisterPassword("testpassword", mk)
+	require.NoError(t, err)
+	require.NoError(t, db.Rename(pw.ID, "Password"))
+
+	rows := authRows(t, path)
+	require.Equal(t, 5, len(rows))
+	for _, row := range rows {
+		require.Equal(t, "", row.Type)
+		require.Equal(t, "", row.Name)
+		require.Equal(t, 32, len(row.Salt))
+		require.Equal(t, 80, len(row.EK))
+		require.Equal(t, len(rows[0].Meta), len(row.Meta))
+	}
+
+	auths, err := db.List()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(auths))
+	byID := map[string]*auth.Auth{}
+	for _, a := range auths {
+		byID[a.ID] = a
+	}
+	require.Equal(t, api.PaperKeyType, byID[pk.ID].Type)
+	require.Equal(t, api.PasswordType, byID[pw.ID].Type)
+	require.Equal(t, "Password", byID[pw.ID].Name)
+	require.Equal(t, pw.CreatedAt.UnixNano(), byID[pw.ID].CreatedAt.UnixNano())
+
+	// Without the master key
+	db.Lock()
+	auths, err = db.List()
+	require.NoError(t, err)
+	require.Equal(t, 5, len(auths))
+	auths, err = db.ListByType(api.PasswordType)
+	require.NoError(t, err)
+	require.Equal(t, 5, len(auths))
+	_, err = db.Verify()
+	require.Equal(t, auth.ErrLocked, err)
+
+	out, mko, err := db.Password("testpassword")
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, pw.ID, out.ID)
+	require.Equal(t, api.PasswordType, out.Type)
+	require.Equal(t, "Password", out.Name)
+	require.Equal(t, 1, out.Uses)
+
+	db.Lock()
+	out, mko, err = db.PaperKey(paperKey)
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, pk.ID, out.ID)
+	require.Equal(t, api.PaperKeyType, out.Type)
+
+	v, err := db.Verify()
+	require.NoError(t, err)
+	require.True(t, v.OK())
+
+	// Decoys are removed when a method is registered (at least 5 entries)
+	_, err = db.RegisterPaperKey(keys.RandPhrase(), mk)
+	require.NoError(t, err)
+	require.Equal(t, 5, len(authRows(t, path)))
+	require.NoError(t, db.Delete(pk.ID))
+	require.Equal(t, 5, len(authRows(t, path)))
+	require.NoError(t, db.Close())
+
+	// Unsealed on unlock without WithSealedMetadata
+	db, err = auth.NewDB(path)
+	require.NoError(t, err)
+	_, _, err = db.Password("testpassword")
+	require.NoError(t, err)
+	rows = authRows(t, path)
+	require.Equal(t, 2, len(rows))
+	for _, row := range rows {
+		require.NotEqual(t, "", row.Type)
+		require.Nil(t, row.Meta)
+	}
+	v, err = db.Verify()
+	require.NoError(t, err)
+	require.True(t, v.OK())
+	require.NoError(t, db.Close())
+
+	// Sealed again on unlock with WithSealedMetadata
+	db, err = auth.NewDB(path, auth.WithSealedMetadata())
+	require.NoError(t, err)
+	defer db.Close()
+	_, _, err = db.Password("testpassword")
+	require.NoError(t, err)
+	rows = authRows(t, path)
+	require.Equal(t, 2, len(rows))
+	for _, row := range rows {
+		require.Equal(t, "", row.Type)
+		require.NotNil(t, row.Meta)
+	}
+	auths, err = db.ListByType(api.PasswordType)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(auths))
+	require.Equal(t, "Password", auths[0].Name)
+}
+
+func TestUnsealedMetadata(t *testing.T) {
+	path := testutil.Path()
+	defer func() { _ = os.Remove(path) }()
+
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	mk := testutil.Seed(0x01)
+	_, err = db.RegisterPassword("testpassword", mk)
+	require.NoError(t, err)
+	rows := authRows(t, path)
+	require.Equal(t, 1, len(rows))
+	require.Equal(t, 24, len(rows[0].Salt))
+
+	// Auth types aren't listed in attempts
+	_, _, err = db.Password("invalidpassword")
+	require.Equal(t, auth.ErrInvalidAuth, err)
+	sdb, err := sqlx.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer sdb.Close()
+	var ids []string
+	err = sdb.Select(&ids, "SELECT type FROM attempts")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(ids))
+	require.NotEqual(t, string(api.PasswordType), ids[0])
+	attempts, err := db.Attempts(api.PasswordType)
+	require.NoError(t, err)
+	require.Equal(t, 1, attempts.Failures)
+
+	// Only sealed auth match any FIDO2 device
+	unsealed := &auth.Auth{ID: "unsealed", Type: api.FIDO2HMACSecretType}
+	sealed := &auth.Auth{ID: "sealed", Type: api.FIDO2HMACSecretType, Meta: []byte{0x01}}
+	matches := auth.MatchAAGUID([]*auth.Auth{unsealed, sealed}, "aaguid")
+	require.Equal(t, []*auth.Auth{sealed}, matches)
+}
+
+func TestSealedUniform(t *testing.T) {
+	path := testutil.Path()
+	defer func() { _ = os.Remove(path) }()
+
+	db, err := auth.NewDB(path, auth.WithSealedMetadata())
+	require.NoError(t, err)
+	defer db.Close()
+	clock := tsutil.NewTestClock()
+	db.SetClock(clock)
+	ctx := context.TODO()
+
+	mk := testutil.Seed(0x01)
+	_, err = db.RegisterPassword("testpassword", mk)
+	require.NoError(t, err)
+	paperKey := keys.RandPhrase()
+	_, err = db.RegisterPaperKey(paperKey, mk)
+	require.NoError(t, err)
+	keyFile := keys.RandBytes(auth.KeyFileSize)
+	_, err = db.RegisterKeyFile(bytes.NewReader(keyFile), mk)
+	require.NoError(t, err)
+	_, token, err := db.RegisterToken(mk, time.Hour)
+	require.NoError(t, err)
+	xk := keys.GenerateX25519Key()
+	_, err = db.RegisterX25519(xk.PublicKey(), mk)
+	require.NoError(t, err)
+	_, shares, err := db.RegisterShamir(mk, 2, 3)
+	require.NoError(t, err)
+	_, err = db.RegisterMultiFactor(ctx, nil, mk,
+		auth.PasswordFactor("testpassword2"),
+		auth.PaperKeyFactor(paperKey))
+	require.NoError(t, err)
+
+	// Stored rows only differ in random values
+	sdb, err := sqlx.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer sdb.Close()
+	var rows []*auth.Auth
+	err = sdb.Select(&rows, "SELECT * FROM auth")
+	require.NoError(t, err)
+	require.Equal(t, 7, len(rows))
+	for _, row := range rows {
+		require.Equal(t, api.Type(""), row.Type)
+		require.Equal(t, 80, len(row.EncryptedKey))
+		require.Equal(t, 32, len(row.Salt))
+		require.Equal(t, len(rows[0].Meta), len(row.Meta))
+		require.Equal(t, rows[0].KDF, row.KDF)
+		require.NotEqual(t, "", row.KDF)
+		require.Equal(t, rows[0].KDFTime, row.KDFTime)
+		require.Equal(t, rows[0].KDFMemory, row.KDFMemory)
+		require.Equal(t, rows[0].KDFThreads, row.KDFThreads)
+		require.Empty(t, row.Fingerprint)
+		require.Equal(t, int64(0), row.ExpiresAt)
+		require.Empty(t, row.Factors)
+		require.Equal(t, 0, row.Threshold)
+		require.Empty(t, row.Shares)
+		require.Equal(t, "", row.KID.String())
+	}
+
+	// Unlock each without the master key
+	db.Lock()
+	out, mko, err := db.KeyFile(bytes.NewReader(keyFile))
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, api.KeyFileType, out.Type)
+	require.NotEmpty(t, out.Fingerprint)
+
+	db.Lock()
+	out, mko, err = db.Token(token)
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, api.TokenType, out.Type)
+	require.NotEqual(t, int64(0), out.ExpiresAt)
+
+	db.Lock()
+	out, mko, err = db.X25519(xk)
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, api.X25519Type, out.Type)
+	require.Equal(t, 80, len(out.EncryptedKey))
+
+	db.Lock()
+	recovery := db.ShamirRecovery()
+	_, err = recovery.Add(shares[1])
+	require.NoError(t, err)
+	_, err = recovery.Add(shares[2])
+	require.NoError(t, err)
+	out, mko, err = recovery.Unlock()
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, api.ShamirType, out.Type)
+	require.Equal(t, 2, out.Threshold)
+
+	db.Lock()
+	out, mko, err = db.MultiFactor(ctx, nil,
+		auth.PaperKeyFactor(paperKey),
+		auth.PasswordFactor("testpassword2"))
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, api.MultiFactorType, out.Type)
+	require.Equal(t, 2, len(out.Factors))
+
+	// Expiry is sealed
+	db.Lock()
+	clock.Add(time.Hour)
+	_, _, err = db.Token(token)
+	require.Equal(t, auth.ErrExpired, err)
+}
@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"time"
+
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/encoding"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidShare if a share doesn't belong to shares registered for this
+// auth db.
+var ErrInvalidShare = errors.New("share doesn't belong to this vault")
+
+// shamirSecretSize is the size of the secret split into shares, so a share
+// (index and value) is 32 bytes, the size of a paper key.
+const shamirSecretSize = 31
+
+// NewShamir creates Shamir secret sharing (threshold) auth, returning the auth
+// and count shares (as paper key phrases), any threshold of which can unlock.
+func NewShamir(mk *[32]byte, threshold int, count int) (*Auth, []string, error) {
+	if threshold < 2 {
+		return nil, nil, errors.Errorf("threshold must be at least 2")
+	}
+	if count < threshold || count > 255 {
+		return nil, nil, errors.Errorf("share count must be between threshold and 255")
+	}
+	secret := keys.RandBytes(shamirSecretSize)
+	salt := keys.RandBytes(32)
+	shares, err := shamirSplit(secret, threshold, count)
+	if err != nil {
+		return nil, nil, err
+	}
+	phrases := make([]string, 0, len(shares))
+	info := make(api.Shares, 0, len(shares))
+	for _, share := range shares {
+		phrase, err := encoding.BytesToPhrase(share)
+		if err != nil {
+			return nil, nil, err
+		}
+		phrases = append(phrases, phrase)
+		info = append(info, &api.Share{X: share[0], Hash: shareHash(share, salt)})
+	}
+	auth := &Auth{
+		ID:           encoding.MustEncode(keys.RandBytes(32), encoding.Base62),
+		Type:         api.ShamirType,
+		EncryptedKey: secretBoxSeal(mk[:], shamirKey(secret, salt)),
+		Salt:         salt,
+		Threshold:    threshold,
+		Shares:       info,
+		CreatedAt:    time.Now(),
+	}
+	return auth, phrases, nil
+}
+
+// RegisterShamir registers Shamir secret sharing (threshold) auth, returning
+// the auth and count shares (as paper key phrases), any threshold of which can
+// unlock (see ShamirRecovery).
+// Only the share indexes and hashes (to verify shares) are stored, and with
+// sealed metadata (see WithSealedMetadata), not even those.
+func (d *DB) RegisterShamir(mk *[32]byte, threshold int, count int) (*Auth, []string, error) {
+	if mk == nil {
+		return nil, nil, errors.Errorf("nil master key")
+	}
+	auth, shares, err := NewShamir(mk, threshold, count)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := d.open(mk, nil); err != nil {
+		return nil, nil, err
+	}
+
+	if err := d.Set(auth); err != nil {
+		return nil, nil, err
+	}
+
+	return auth, shares, nil
+}
+
+// ShamirRecovery collects shares, one at a time, until there are enough to
+// unlock.
+type ShamirRecovery struct {
+	d      *DB
+	auth   *Auth
+	shares map[byte][]byte
+	// unverified if shares can't be verified (see Add).
+	unverified bool
+}
+
+// ShamirRecovery starts a recovery with Shamir secret sharing auth.
+func (d *DB) ShamirRecovery() *ShamirRecovery {
+	return &ShamirRecovery{d: d, shares: map[byte][]byte{}}
+}
+
+// Add a share (paper key phrase), returning the number of shares still needed.
+// If the share doesn't belong to this auth db (or to the same shares as
+// previously added shares), returns ErrInvalidShare.
+// With sealed metadata (see WithSealedMetadata), share hashes and the
+// threshold aren't stored, so shares aren't verified until Unlock, and at
+// least 2 shares are needed.
+func (r *ShamirRecovery) Add(share string) (int, error) {
+	b, err := encoding.PhraseToBytes(share, true)
+	if err != nil {
+		return r.Remaining(), ErrInvalidShare
+	}
+	if r.auth == nil && !r.unverified {
+		auths, err := r.d.ListByType(api.ShamirType)
+		if err != nil {
+			return r.Remaining(), err
+		}
+		for _, auth := range auths {
+			if len(auth.Shares) == 0 {
+				r.unverified = true
+				continue
+			}
+			if verifyShare(auth, b[:]) {
+				r.auth, r.unverified = auth, false
+				break
+			}
+		}
+		if r.auth == nil && !r.unverified {
+			return r.Remaining(), ErrInvalidShare
+		}
+	} else if r.auth != nil && !verifyShare(r.auth, b[:]) {
+		return r.Remaining(), ErrInvalidShare
+	}
+	if _, ok := r.shares[b[0]]; ok {
+		return r.Remaining(), errors.Errorf("share was already added")
+	}
+	r.shares[b[0]] = b[:]
+	return r.Remaining(), nil
+}
+
+// Remaining returns the number of shares still needed.
+// If no shares were added, it's unknown, and returns 1.
+// If shares can't be verified (see Add), it's at least 2.
+func (r *ShamirRecovery) Remaining() int {
+	if r.unverified {
+		if n := 2 - len(r.shares); n > 0 {
+			return n
+		}
+		return 0
+	}
+	if r.auth == nil {
+		return 1
+	}
+	if n := r.auth.Threshold - len(r.shares); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// Unlock with the shares, once there are enough (see Remaining).
+// If the shares can't unlock (or weren't verified and there aren't enough),
+// returns ErrInvalidAuth.
+// Failed attempts are limited, see WithBackoff and WithLockout; if limited,
+// returns ErrTooManyAttempts.
+func (r *ShamirRecovery) Unlock() (*Auth, *[32]byte, error) {
+	return r.unlock(false)
+}
+
+// Verify checks the shares, like Unlock, to re-confirm them while already
+// unlocked (see attempt).
+func (r *ShamirRecovery) Verify() (*Auth, *[32]byte, error) {
+	return r.unlock(true)
+}
+
+func (r *ShamirRecovery) unlock(verifying bool) (*Auth, *[32]byte, error) {
+	if r.Remaining() > 0 {
+		return nil, nil, errors.Errorf("%d more shares needed", r.Remaining())
+	}
+	return r.d.attempt(api.ShamirType, verifying, func() (*Auth, *[32]byte, error) {
+		shares := make([][]byte, 0, len(r.shares))
+		for _, share := range r.shares {
+			shares = append(shares, share)
+		}
+		secret, err := shamirCombine(shares)
+		if err != nil {
+			return nil, nil, ErrInvalidAuth
+		}
+		auths := []*Auth{r.auth}
+		if r.auth == nil {
+			auths, err = r.d.ListByType(api.ShamirType)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "failed to auth")
+			}
+		}
+		for _, auth := range auths {
+			if len(auth.Salt) == 0 {
+				continue
+			}
+			mk := r.d.unlock(auth, shamirKey(secret, auth.Salt))
+			if mk == nil {
+				continue
+			}
+			return auth, mk, nil
+		}
+		return nil, nil, ErrInvalidAuth
+	})
+}
+
+func verifyShare(auth *Auth, share []byte) bool {
+	if len(auth.Shares) == 0 || len(auth.Salt) == 0 {
+		return false
+	}
+	for _, s := range auth.Shares {
+		if s.X == share[0] {
+			return subtle.ConstantTimeCompare(s.Hash, shareHash(share, auth.Salt)) == 1
+		}
+	}
+	return false
+}
+
+func shamirKey(secret []byte, salt []byte) *[32]byte {
+	return keys.Bytes32(keys.HKDFSHA256(secret, 32, salt, []byte("keyring/auth/shamir")))
+}
+
+// shareHash verifies a share (it's not the share).
+func shareHash(share []byte, salt []byte) []byte {
+	return keys.HKDFSHA256(share, 32, salt, []byte("keyring/auth/shamir/share"))
+}
+
+// shamirSplit splits a secret into count shares, any threshold of which can
+// recover it. Each share is the index (1-255) followed by a value of the size
+// of the secret.
+func shamirSplit(secret []byte, threshold int, count int) ([][]byte, error) {
+	if threshold < 2 || count < threshold || count > 255 {
+		return nil, errors.Errorf("invalid threshold")
+	}
+	// For each byte of the secret, a random polynomial of degree threshold-1
+	// with the secret byte as the constant term.
+	coeffs := make([][]byte, len(secret))
+	for i, s := range secret {
+		coeffs[i] = append([]byte{s}, keys.RandBytes(threshold-1)...)
+	}
+	shares := make([][]byte, 0, count)
+	for x := 1; x <= count; x++ {
+		share := make([]byte, len(secret)+1)
+		share[0] = byte(x)
+		for i := range secret {
+			share[i+1] = gfEval(coeffs[i], byte(x))
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// shamirCombine recovers a secret from shares (see shamirSplit), using
+// Lagrange interpolation at 0.
+// With fewer than the threshold shares, the result is (silently) wrong.
+func shamirCombine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.Errorf("not enough shares")
+	}
+	size := len(shares[0])
+	seen := map[byte]bool{}
+	for _, share := range shares {
+		if len(share) != size || size < 2 {
+			return nil, errors.Errorf("invalid share")
+		}
+		if share[0] == 0 || seen[share[0]] {
+			return nil, errors.Errorf("invalid share")
+		}
+		seen[share[0]] = true
+	}
+	secret := make([]byte, size-1)
+	for i, si := range shares {
+		// Lagrange basis polynomial for share i at 0.
+		basis := byte(1)
+		for j, sj := range shares {
+			if i == j {
+				continue
+			}
+			basis = gfMul(basis, gfDiv(sj[0], sj[0]^si[0]))
+		}
+		for k := range secret {
+			secret[k] ^= gfMul(si[k+1], basis)
+		}
+	}
+	return secret, nil
+}
+
+// gfEval evaluates a polynomial (coefficients, constant term first) at x in
+// GF(2^8).
+func gfEval(coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial (x^8 + x^4 + x^3 + x +
+// 1), without branching on the values.
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		hi := a >> 7
+		a = (a << 1) ^ (0x1b & -hi)
+		b >>= 1
+	}
+	return p
+}
+
+// gfDiv divides in GF(2^8), where b is not 0.
+func gfDiv(a, b byte) byte {
+	// b^-1 = b^254
+	inv := byte(1)
+	for i := 0; i < 254; i++ {
+		inv = gfMul(inv, b)
+	}
+	return gfMul(a, inv)
+}
@@ -0,0 +1,111 @@
+package auth_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/encoding"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShamir(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+
+	mk := testutil.Seed(0x01)
+	_, _, err = db.RegisterShamir(mk, 1, 3)
+	require.EqualError(t, err, "threshold must be at least 2")
+	_, _, err = db.RegisterShamir(mk, 3, 2)
+	require.EqualError(t, err, "share count must be between threshold and 255")
+
+	reg, shares, err := db.RegisterShamir(mk, 3, 5)
+	require.NoError(t, err)
+	require.Equal(t, api.ShamirType, reg.Type)
+	require.Equal(t, 3, reg.Threshold)
+	require.Equal(t, 5, len(reg.Shares))
+	require.Equal(t, 5, len(shares))
+	for _, share := range shares {
+		_, err := encoding.PhraseToBytes(share, true)
+		require.NoError(t, err)
+	}
+
+	// Any 3 shares
+	for _, idx := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}} {
+		recovery := db.ShamirRecovery()
+		require.Equal(t, 1, recovery.Remaining())
+		for i, n := range idx {
+			remaining, err := recovery.Add(shares[n])
+			require.NoError(t, err)
+			require.Equal(t, 2-i, remaining)
+		}
+		out, mko, err := recovery.Unlock()
+		require.NoError(t, err)
+		require.Equal(t, mk, mko)
+		require.Equal(t, reg.ID, out.ID)
+	}
+
+	recovery := db.ShamirRecovery()
+	_, err = recovery.Add(shares[0])
+	require.NoError(t, err)
+	_, _, err = recovery.Unlock()
+	require.EqualError(t, err, "2 more shares needed")
+
+	// Duplicate share
+	_, err = recovery.Add(shares[0])
+	require.EqualError(t, err, "share was already added")
+
+	// Share from another vault
+	_, other, err := auth.NewShamir(testutil.Seed(0x02), 3, 5)
+	require.NoError(t, err)
+	_, err = recovery.Add(other[1])
+	require.Equal(t, auth.ErrInvalidShare, err)
+	_, err = recovery.Add(keys.RandPhrase())
+	require.Equal(t, auth.ErrInvalidShare, err)
+	_, err = recovery.Add("invalid phrase")
+	require.Equal(t, auth.ErrInvalidShare, err)
+	require.Equal(t, 2, recovery.Remaining())
+
+	// Share from another set in this vault
+	_, shares2, err := db.RegisterShamir(mk, 2, 2)
+	require.NoError(t, err)
+	_, err = recovery.Add(shares2[1])
+	require.Equal(t, auth.ErrInvalidShare, err)
+	recovery2 := db.ShamirRecovery()
+	_, err = recovery2.Add(shares2[1])
+	require.NoError(t, err)
+	remaining, err := recovery2.Add(shares2[0])
+	require.NoError(t, err)
+	require.Equal(t, 0, remaining)
+	_, mko, err := recovery2.Unlock()
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+}
+
+func TestShamirSealed(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path, auth.WithSealedMetadata())
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+
+	mk := testutil.Seed(0x01)
+	reg, shares, err := db.RegisterShamir(mk, 2, 3)
+	require.NoError(t, err)
+	db.Lock()
+
+	recovery := db.ShamirRecovery()
+	_, err = recovery.Add(shares[2])
+	require.NoError(t, err)
+	_, err = recovery.Add(shares[0])
+	require.NoError(t, err)
+	out, mko, err := recovery.Unlock()
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, reg.ID, out.ID)
+	require.Equal(t, api.ShamirType, out.Type)
+}
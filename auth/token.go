@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/encoding"
+	"github.com/pkg/errors"
+)
+
+// TokenPrefix is the prefix for encoded tokens.
+const TokenPrefix = "kr_"
+
+// ErrExpired if auth has expired.
+var ErrExpired = errors.New("auth expired")
+
+// NewToken creates token auth, returning the auth and the encoded token.
+// If expiresAt is 0, the token doesn't expire.
+func NewToken(mk *[32]byte, expiresAt int64) (*Auth, string, error) {
+	id := encoding.MustEncode(keys.RandBytes(32), encoding.Base62)
+	secret := keys.Rand32()
+	ek := secretBoxSeal(mk[:], tokenKey(secret))
+	auth := &Auth{
+		ID:           id,
+		Type:         api.TokenType,
+		EncryptedKey: ek,
+		ExpiresAt:    expiresAt,
+		CreatedAt:    time.Now(),
+	}
+	return auth, encodeToken(id, secret), nil
+}
+
+// RegisterToken registers token auth, returning the auth and the encoded
+// token, which should be kept secret.
+// If expire is 0, the token doesn't expire.
+func (d *DB) RegisterToken(mk *[32]byte, expire time.Duration) (*Auth, string, error) {
+	if mk == nil {
+		return nil, "", errors.Errorf("nil master key")
+	}
+	var expiresAt int64
+	if expire > 0 {
+		expiresAt = d.clock.NowMillis() + int64(expire/time.Millisecond)
+	}
+	auth, token, err := NewToken(mk, expiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := d.open(mk, nil); err != nil {
+		return nil, "", err
+	}
+
+	if err := d.Set(auth); err != nil {
+		return nil, "", err
+	}
+
+	return auth, token, nil
+}
+
+// Token authenticates using an encoded token.
+// If the token has expired, returns ErrExpired.
+// Failed attempts are limited, see WithBackoff and WithLockout; if limited,
+// returns ErrTooManyAttempts.
+func (d *DB) Token(token string) (*Auth, *[32]byte, error) {
+	return d.attempt(api.TokenType, false, func() (*Auth, *[32]byte, error) {
+		return d.token(token)
+	})
+}
+
+// VerifyToken checks an encoded token, like Token, to re-confirm it while
+// already unlocked (see attempt).
+func (d *DB) VerifyToken(token string) (*Auth, *[32]byte, error) {
+	return d.attempt(api.TokenType, true, func() (*Auth, *[32]byte, error) {
+		return d.token(token)
+	})
+}
+
+// RevokeToken removes token auth, returning the removed auth.
+// The token can be the encoded token or the auth ID.
+// If not found, returns keys.ErrNotFound.
+func (d *DB) RevokeToken(token string) (*Auth, error) {
+	id := token
+	if strings.HasPrefix(token, TokenPrefix) {
+		tid, _, err := decodeToken(token)
+		if err != nil {
+			return nil, err
+		}
+		id = tid
+	}
+	auths, err := d.ListByType(api.TokenType)
+	if err != nil {
+		return nil, err
+	}
+	for _, auth := range auths {
+		if auth.ID == id {
+			if err := d.Delete(id); err != nil {
+				return nil, err
+			}
+			return auth, nil
+		}
+	}
+	return nil, keys.NewErrNotFound(id)
+}
+
+func (d *DB) token(token string) (*Auth, *[32]byte, error) {
+	id, secret, err := decodeToken(token)
+	if err != nil {
+		return nil, nil, ErrInvalidAuth
+	}
+	auths, err := d.ListByType(api.TokenType)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to auth")
+	}
+
+	for _, auth := range auths {
+		if auth.ID != id {
+			continue
+		}
+		mk := d.unlock(auth, tokenKey(secret))
+		if mk == nil {
+			return nil, nil, ErrInvalidAuth
+		}
+		expiresAt := auth.ExpiresAt
+		if auth.Type == "" {
+			// Sealed metadata (see sealAuth) has the expiry
+			meta, err := openMeta(auth.Meta, metaKey(mk))
+			if err != nil || meta.Type != api.TokenType {
+				return nil, nil, ErrInvalidAuth
+			}
+			expiresAt = meta.ExpiresAt
+		}
+		if expiresAt != 0 && d.clock.NowMillis() >= expiresAt {
+			return nil, nil, ErrExpired
+		}
+		return auth, mk, nil
+	}
+	return nil, nil, ErrInvalidAuth
+}
+
+func tokenKey(secret *[32]byte) *[32]byte {
+	return keys.Bytes32(keys.HKDFSHA256(secret[:], 32, nil, []byte("keyring/auth/token")))
+}
+
+func encodeToken(id string, secret *[32]byte) string {
+	return TokenPrefix + id + "_" + encoding.MustEncode(secret[:], encoding.Base62)
+}
+
+func decodeToken(token string) (string, *[32]byte, error) {
+	token = strings.TrimSpace(token)
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return "", nil, errors.Errorf("invalid token")
+	}
+	parts := strings.Split(strings.TrimPrefix(token, TokenPrefix), "_")
+	if len(parts) != 2 || parts[0] == "" {
+		return "", nil, errors.Errorf("invalid token")
+	}
+	b, err := encoding.Decode(parts[1], encoding.Base62)
+	if err != nil || len(b) != 32 {
+		return "", nil, errors.Errorf("invalid token")
+	}
+	return parts[0], keys.Bytes32(b), nil
+}
@@ -0,0 +1,72 @@
+package auth_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys/tsutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToken(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+	clock := tsutil.NewTestClock()
+	db.SetClock(clock)
+
+	mk := testutil.Seed(0x01)
+	reg, token, err := db.RegisterToken(mk, 0)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(token, auth.TokenPrefix+reg.ID+"_"))
+	require.Equal(t, int64(0), reg.ExpiresAt)
+
+	out, mko, err := db.Token(token)
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, reg.ID, out.ID)
+
+	// Whitespace from copy and paste
+	_, _, err = db.Token(" " + token + "\n")
+	require.NoError(t, err)
+
+	// Wrong secret
+	_, token2, err := auth.NewToken(mk, 0)
+	require.NoError(t, err)
+	invalid := token[:strings.LastIndex(token, "_")] + token2[strings.LastIndex(token2, "_"):]
+	_, _, err = db.Token(invalid)
+	require.EqualError(t, err, "invalid auth")
+	_, _, err = db.Token("kr_invalid")
+	require.EqualError(t, err, "invalid auth")
+
+	// Expiry
+	exp, expToken, err := db.RegisterToken(mk, time.Hour)
+	require.NoError(t, err)
+	require.True(t, exp.ExpiresAt > 0)
+	_, _, err = db.Token(expToken)
+	require.NoError(t, err)
+	clock.Add(time.Hour)
+	_, _, err = db.Token(expToken)
+	require.Equal(t, auth.ErrExpired, err)
+
+	// Revoke
+	revoked, err := db.RevokeToken(token)
+	require.NoError(t, err)
+	require.Equal(t, reg.ID, revoked.ID)
+	_, _, err = db.Token(token)
+	require.EqualError(t, err, "invalid auth")
+	_, err = db.RevokeToken(exp.ID)
+	require.NoError(t, err)
+	_, err = db.RevokeToken(exp.ID)
+	require.EqualError(t, err, exp.ID+" not found")
+
+	auths, err := db.ListByType(api.TokenType)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(auths))
+}
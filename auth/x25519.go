@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/encoding"
+	"github.com/pkg/errors"
+)
+
+// NewX25519 creates auth with the master key sealed to an X25519 public key.
+func NewX25519(publicKey *keys.X25519PublicKey, mk *[32]byte) (*Auth, error) {
+	if publicKey == nil {
+		return nil, errors.Errorf("nil public key")
+	}
+	id := encoding.MustEncode(keys.RandBytes(32), encoding.Base62)
+	ek := keys.CryptoBoxSeal(mk[:], publicKey)
+	return &Auth{
+		ID:           id,
+		Type:         api.X25519Type,
+		EncryptedKey: ek,
+		KID:          publicKey.ID(),
+		CreatedAt:    time.Now(),
+	}, nil
+}
+
+// RegisterX25519 registers X25519 public key auth.
+// Whoever has the private key for the public key can unlock.
+func (d *DB) RegisterX25519(publicKey *keys.X25519PublicKey, mk *[32]byte) (*Auth, error) {
+	if mk == nil {
+		return nil, errors.Errorf("nil master key")
+	}
+	auth, err := NewX25519(publicKey, mk)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.open(mk, nil); err != nil {
+		return nil, err
+	}
+
+	if err := d.Set(auth); err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+// X25519 authenticates using an X25519 private key.
+// Failed attempts are limited, see WithBackoff and WithLockout; if limited,
+// returns ErrTooManyAttempts.
+func (d *DB) X25519(key *keys.X25519Key) (*Auth, *[32]byte, error) {
+	return d.attempt(api.X25519Type, false, func() (*Auth, *[32]byte, error) {
+		return d.x25519(key)
+	})
+}
+
+// VerifyX25519 checks an X25519 key, like X25519, to re-confirm it while
+// already unlocked (see attempt).
+func (d *DB) VerifyX25519(key *keys.X25519Key) (*Auth, *[32]byte, error) {
+	return d.attempt(api.X25519Type, true, func() (*Auth, *[32]byte, error) {
+		return d.x25519(key)
+	})
+}
+
+func (d *DB) x25519(key *keys.X25519Key) (*Auth, *[32]byte, error) {
+	if key == nil {
+		return nil, nil, ErrInvalidAuth
+	}
+	auths, err := d.ListByType(api.X25519Type)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to auth")
+	}
+
+	for _, auth := range auths {
+		// Auth with sealed metadata has no kid, so we try to open those.
+		if auth.KID != "" && auth.KID != key.ID() {
+			continue
+		}
+		b, err := keys.CryptoBoxSealOpen(auth.EncryptedKey, key)
+		if err != nil || len(b) != 32 {
+			continue
+		}
+		return auth, keys.Bytes32(b), nil
+	}
+	return nil, nil, ErrInvalidAuth
+}
@@ -0,0 +1,63 @@
+package auth_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/getchill-app/keyring/auth/api"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/stretchr/testify/require"
+)
+
+func TestX25519(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+
+	mk := testutil.Seed(0x01)
+	key := keys.GenerateX25519Key()
+
+	reg, err := db.RegisterX25519(key.PublicKey(), mk)
+	require.NoError(t, err)
+	require.Equal(t, key.ID(), reg.KID)
+
+	auths, err := db.ListByType(api.X25519Type)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(auths))
+	require.Equal(t, key.ID(), auths[0].KID)
+
+	out, mko, err := db.X25519(key)
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, reg.ID, out.ID)
+
+	_, _, err = db.X25519(keys.GenerateX25519Key())
+	require.EqualError(t, err, "invalid auth")
+
+	_, _, err = db.X25519(nil)
+	require.EqualError(t, err, "invalid auth")
+}
+
+func TestX25519Sealed(t *testing.T) {
+	path := testutil.Path()
+	db, err := auth.NewDB(path, auth.WithDecoys(3))
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(path) }()
+
+	mk := testutil.Seed(0x01)
+	key := keys.GenerateX25519Key()
+
+	reg, err := db.RegisterX25519(key.PublicKey(), mk)
+	require.NoError(t, err)
+	db.Lock()
+
+	out, mko, err := db.X25519(key)
+	require.NoError(t, err)
+	require.Equal(t, mk, mko)
+	require.Equal(t, reg.ID, out.ID)
+	require.Equal(t, api.X25519Type, out.Type)
+	require.Equal(t, key.ID(), out.KID)
+}
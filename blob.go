@@ -0,0 +1,301 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"hash"
+	"io"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/api"
+	"github.com/pkg/errors"
+)
+
+// BlobChunkSize is the size of chunks blobs are stored in.
+const BlobChunkSize = 64 * 1024
+
+// ErrBlobInvalid if blob content doesn't match its hash.
+var ErrBlobInvalid = errors.New("blob is invalid")
+
+// Blob describes a stored blob (file).
+type Blob struct {
+	Name string `db:"name"`
+	// Size in bytes.
+	Size   int64 `db:"size"`
+	Chunks int64 `db:"chunks"`
+	// Hash (SHA256) of the blob content.
+	Hash []byte `db:"hash"`
+	// KID is the key this blob is linked to (optional).
+	KID    keys.ID    `db:"kid"`
+	Labels api.Labels `db:"labels"`
+
+	CreatedAt int64 `db:"createdAt"`
+}
+
+// BlobOption for PutBlob.
+type BlobOption func(*Blob)
+
+// WithBlobKey links a blob to a key.
+func WithBlobKey(kid keys.ID) BlobOption {
+	return func(b *Blob) {
+		b.KID = kid
+	}
+}
+
+// WithBlobLabels adds labels to a blob.
+func WithBlobLabels(labels ...string) BlobOption {
+	return func(b *Blob) {
+		b.Labels = append(b.Labels, labels...)
+	}
+}
+
+// PutBlob stores a blob from a reader, replacing any existing blob with the
+// same name.
+// The content is stored in chunks, so it doesn't need to fit in memory.
+// Requires Unlock.
+func (k *Keyring) PutBlob(name string, r io.Reader, opt ...BlobOption) (*Blob, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.Errorf("empty blob name")
+	}
+	blob := &Blob{Name: name, CreatedAt: k.clock.NowMillis()}
+	for _, o := range opt {
+		o(blob)
+	}
+
+	if err := Transact(k.db, func(tx *sqlx.Tx) error {
+		if err := checkBlobKeyTx(tx, blob.KID); err != nil {
+			return err
+		}
+		if err := deleteBlobTx(tx, name); err != nil {
+			return err
+		}
+		h := sha256.New()
+		buf := make([]byte, BlobChunkSize)
+		for {
+			n, err := io.ReadFull(r, buf)
+			if n > 0 {
+				if _, err := tx.Exec(`INSERT INTO blobChunks (name, idx, data) VALUES ($1, $2, $3)`, name, blob.Chunks, buf[:n]); err != nil {
+					return err
+				}
+				_, _ = h.Write(buf[:n])
+				blob.Size += int64(n)
+				blob.Chunks++
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to read blob")
+			}
+		}
+		blob.Hash = h.Sum(nil)
+
+		logger.Debugf("Saving blob %s (%d bytes)", name, blob.Size)
+		if _, err := tx.NamedExec(`INSERT INTO blobs VALUES
+			(:name, :size, :chunks, :hash, :kid, :labels, :createdAt)`, blob); err != nil {
+			return err
+		}
+		return auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: AuditBlobPut, KID: blob.KID, Actor: k.actor, Detail: name})
+	}); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// GetBlob returns a reader for a blob.
+// The content is read in chunks and checked against the blob hash; if it
+// doesn't match, Read returns ErrBlobInvalid at the end of the content.
+// If not found, returns keys.ErrNotFound.
+// Requires Unlock.
+func (k *Keyring) GetBlob(name string) (io.ReadCloser, error) {
+	blob, err := k.Blob(name)
+	if err != nil {
+		return nil, err
+	}
+	if blob == nil {
+		return nil, keys.NewErrNotFound(name)
+	}
+	if err := Transact(k.db, func(tx *sqlx.Tx) error {
+		return auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: AuditBlobGet, KID: blob.KID, Actor: k.actor, Detail: name})
+	}); err != nil {
+		return nil, err
+	}
+	return &blobReader{db: k.db, blob: blob, hash: sha256.New()}, nil
+}
+
+// Blob returns blob info.
+// Returns nil if not found.
+// Requires Unlock.
+func (k *Keyring) Blob(name string) (*Blob, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	var blob Blob
+	if err := k.db.Get(&blob, "SELECT * FROM blobs WHERE name = $1", name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// Blobs returns info for all blobs.
+// Requires Unlock.
+func (k *Keyring) Blobs() ([]*Blob, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	return getBlobs(k.db, "SELECT * FROM blobs ORDER BY name")
+}
+
+// BlobsForKey returns info for blobs linked to a key.
+// Requires Unlock.
+func (k *Keyring) BlobsForKey(kid keys.ID) ([]*Blob, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	return getBlobs(k.db, "SELECT * FROM blobs WHERE kid = $1 ORDER BY name", kid)
+}
+
+// BlobsWithLabel returns info for blobs with label.
+// Requires Unlock.
+func (k *Keyring) BlobsWithLabel(label string) ([]*Blob, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	sqlLabel := "%^" + label + "$%"
+	return getBlobs(k.db, "SELECT * FROM blobs WHERE labels LIKE $1 ORDER BY name", sqlLabel)
+}
+
+// LinkBlob links a blob to a key and/or labels, replacing any existing links.
+// If the key (if specified) or blob isn't found, returns keys.ErrNotFound.
+// Requires Unlock.
+func (k *Keyring) LinkBlob(name string, kid keys.ID, labels ...string) error {
+	if err := k.initDB(); err != nil {
+		return err
+	}
+	return Transact(k.db, func(tx *sqlx.Tx) error {
+		if err := checkBlobKeyTx(tx, kid); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`UPDATE blobs SET kid = $1, labels = $2 WHERE name = $3`, kid, api.Labels(labels), name)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return keys.NewErrNotFound(name)
+		}
+		return auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: AuditBlobLink, KID: kid, Actor: k.actor, Detail: name})
+	})
+}
+
+// RemoveBlob removes a blob.
+// Requires Unlock.
+func (k *Keyring) RemoveBlob(name string) error {
+	if err := k.initDB(); err != nil {
+		return err
+	}
+	return Transact(k.db, func(tx *sqlx.Tx) error {
+		if err := deleteBlobTx(tx, name); err != nil {
+			return err
+		}
+		return auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: AuditBlobRemove, Actor: k.actor, Detail: name})
+	})
+}
+
+type blobReader struct {
+	db   *sqlx.DB
+	blob *Blob
+	hash hash.Hash
+
+	idx    int64
+	buf    []byte
+	closed bool
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, errors.Errorf("blob reader closed")
+	}
+	for len(r.buf) == 0 {
+		if r.idx >= r.blob.Chunks {
+			if !bytes.Equal(r.hash.Sum(nil), r.blob.Hash) {
+				return 0, ErrBlobInvalid
+			}
+			return 0, io.EOF
+		}
+		var data []byte
+		if err := r.db.Get(&data, "SELECT data FROM blobChunks WHERE name = $1 AND idx = $2", r.blob.Name, r.idx); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, ErrBlobInvalid
+			}
+			return 0, err
+		}
+		_, _ = r.hash.Write(data)
+		r.buf = data
+		r.idx++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *blobReader) Close() error {
+	r.closed = true
+	r.buf = nil
+	return nil
+}
+
+func deleteBlobTx(tx *sqlx.Tx, name string) error {
+	if _, err := tx.Exec(`DELETE FROM blobChunks WHERE name = ?`, name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM blobs WHERE name = ?`, name); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkBlobKeyTx checks the key a blob is linked to (if any) exists.
+func checkBlobKeyTx(tx *sqlx.Tx, kid keys.ID) error {
+	if kid == "" {
+		return nil
+	}
+	key, err := getKeyTx(tx, kid)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return keys.NewErrNotFound(kid.String())
+	}
+	return nil
+}
+
+// unlinkBlobsTx removes links from blobs to a key.
+func unlinkBlobsTx(tx *sqlx.Tx, kid keys.ID) error {
+	if _, err := tx.Exec(`UPDATE blobs SET kid = '' WHERE kid = ?`, kid); err != nil {
+		return err
+	}
+	return nil
+}
+
+func getBlobs(db *sqlx.DB, query string, args ...interface{}) ([]*Blob, error) {
+	var out []*Blob
+	if err := db.Select(&out, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return out, nil
+}
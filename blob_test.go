@@ -0,0 +1,107 @@
+package keyring_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"testing"
+
+	"github.com/getchill-app/keyring"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobs(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn()
+
+	sk := keys.GenerateEdX25519Key()
+	err = kr.Set(api.NewKey(sk))
+	require.NoError(t, err)
+
+	b := keys.RandBytes(keyring.BlobChunkSize*3 + 100)
+	blob, err := kr.PutBlob("cert.pem", bytes.NewReader(b), keyring.WithBlobKey(sk.ID()), keyring.WithBlobLabels("tls"))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(b)), blob.Size)
+	require.Equal(t, int64(4), blob.Chunks)
+	h := sha256.Sum256(b)
+	require.Equal(t, h[:], blob.Hash)
+
+	r, err := kr.GetBlob("cert.pem")
+	require.NoError(t, err)
+	out, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, b, out)
+
+	_, err = kr.PutBlob("empty", bytes.NewReader([]byte{}))
+	require.NoError(t, err)
+	r, err = kr.GetBlob("empty")
+	require.NoError(t, err)
+	out, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(out))
+
+	blobs, err := kr.BlobsForKey(sk.ID())
+	require.NoError(t, err)
+	require.Equal(t, 1, len(blobs))
+	blobs, err = kr.BlobsWithLabel("tls")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(blobs))
+
+	err = kr.LinkBlob("empty", "", "tls", "other")
+	require.NoError(t, err)
+	blobs, err = kr.BlobsWithLabel("tls")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(blobs))
+	err = kr.LinkBlob("unknown", "")
+	require.EqualError(t, err, "unknown not found")
+	unknown := keys.GenerateEdX25519Key().ID()
+	err = kr.LinkBlob("empty", unknown)
+	require.EqualError(t, err, unknown.String()+" not found")
+	_, err = kr.PutBlob("unknown", bytes.NewReader([]byte{}), keyring.WithBlobKey(unknown))
+	require.EqualError(t, err, unknown.String()+" not found")
+
+	entries, err := kr.AuditLog(keyring.AuditFilter{Op: keyring.AuditBlobLink})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(entries))
+	require.Equal(t, "empty", entries[0].Detail)
+
+	// Removing the key removes links to it
+	err = kr.LinkBlob("empty", sk.ID())
+	require.NoError(t, err)
+	err = kr.Remove(sk.ID())
+	require.NoError(t, err)
+	blobs, err = kr.BlobsForKey(sk.ID())
+	require.NoError(t, err)
+	require.Equal(t, 0, len(blobs))
+
+	// Replace
+	_, err = kr.PutBlob("cert.pem", bytes.NewReader([]byte("replaced")))
+	require.NoError(t, err)
+	r, err = kr.GetBlob("cert.pem")
+	require.NoError(t, err)
+	out, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, []byte("replaced"), out)
+
+	// Tamper
+	_, err = kr.DB().Exec("UPDATE blobChunks SET data = ? WHERE name = ?", []byte("tampered"), "cert.pem")
+	require.NoError(t, err)
+	r, err = kr.GetBlob("cert.pem")
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	require.Equal(t, keyring.ErrBlobInvalid, err)
+
+	err = kr.RemoveBlob("cert.pem")
+	require.NoError(t, err)
+	_, err = kr.GetBlob("cert.pem")
+	require.EqualError(t, err, "cert.pem not found")
+
+	blobs, err = kr.Blobs()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(blobs))
+}
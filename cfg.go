@@ -6,15 +6,19 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/keys-pub/keys"
 	"github.com/keys-pub/keys/encoding"
+	"github.com/keys-pub/keys/tsutil"
 	"github.com/pkg/errors"
 )
 
 type Config struct {
-	db *sqlx.DB
+	db       *sqlx.DB
+	actor    string
+	auditKey *[32]byte
+	clock    tsutil.Clock
 }
 
 func (k *Keyring) Config() Config {
-	return Config{k.db}
+	return Config{db: k.db, actor: k.actor, auditKey: k.auditKey, clock: k.clock}
 }
 
 func (c Config) String(k string) (string, error) {
@@ -28,7 +32,9 @@ func (c Config) SetString(k string, v string) error {
 	if c.db == nil {
 		return ErrLocked
 	}
-	return setConfig(c.db, k, v)
+	return c.set(k, func(tx *sqlx.Tx) error {
+		return setConfigTx(tx, k, v)
+	})
 }
 
 func (c Config) Bytes(k string) ([]byte, error) {
@@ -42,14 +48,18 @@ func (c Config) SetBytes(k string, v []byte) error {
 	if c.db == nil {
 		return ErrLocked
 	}
-	return setConfigBytes(c.db, k, v)
+	return c.set(k, func(tx *sqlx.Tx) error {
+		return setConfigBytesTx(tx, k, v)
+	})
 }
 
 func (c Config) Set(k string, v string) error {
 	if c.db == nil {
 		return ErrLocked
 	}
-	return setConfig(c.db, k, v)
+	return c.set(k, func(tx *sqlx.Tx) error {
+		return setConfigTx(tx, k, v)
+	})
 }
 
 func (c Config) KID(k string) (keys.ID, error) {
@@ -71,7 +81,18 @@ func (c Config) SetKID(k string, v keys.ID) error {
 	if c.db == nil {
 		return ErrLocked
 	}
-	return setConfig(c.db, k, string(v))
+	return c.set(k, func(tx *sqlx.Tx) error {
+		return setConfigTx(tx, k, string(v))
+	})
+}
+
+func (c Config) set(k string, setFn func(tx *sqlx.Tx) error) error {
+	return Transact(c.db, func(tx *sqlx.Tx) error {
+		if err := setFn(tx); err != nil {
+			return err
+		}
+		return auditTx(tx, c.auditKey, c.clock, &AuditEntry{Op: AuditConfig, Actor: c.actor, Detail: k})
+	})
 }
 
 func setConfig(db *sqlx.DB, key string, value string) error {
@@ -81,6 +102,13 @@ func setConfig(db *sqlx.DB, key string, value string) error {
 	return nil
 }
 
+func setConfigTx(tx *sqlx.Tx, key string, value string) error {
+	if _, err := tx.Exec("INSERT OR REPLACE INTO config (key, value) VALUES ($1, $2)", key, value); err != nil {
+		return errors.Wrapf(err, "failed to set config")
+	}
+	return nil
+}
+
 func getConfig(db *sqlx.DB, key string) (string, error) {
 	var value string
 	if err := db.Get(&value, "SELECT value FROM config WHERE key=$1", key); err != nil {
@@ -92,11 +120,11 @@ func getConfig(db *sqlx.DB, key string) (string, error) {
 	return value, nil
 }
 
-func setConfigBytes(db *sqlx.DB, key string, b []byte) error {
+func setConfigBytesTx(tx *sqlx.Tx, key string, b []byte) error {
 	if len(b) == 0 {
-		return setConfig(db, key, "")
+		return setConfigTx(tx, key, "")
 	}
-	return setConfig(db, key, encoding.MustEncode(b, encoding.Base64))
+	return setConfigTx(tx, key, encoding.MustEncode(b, encoding.Base64))
 }
 
 func getConfigBytes(db *sqlx.DB, key string) ([]byte, error) {
@@ -0,0 +1,71 @@
+package keyring
+
+import (
+	"github.com/keys-pub/keys"
+	"github.com/pkg/errors"
+)
+
+// Sign bytes with an EdX25519 key in the keyring.
+// Requires Unlock.
+func (k *Keyring) Sign(kid keys.ID, b []byte) ([]byte, error) {
+	sk, err := k.edx25519Key(kid)
+	if err != nil {
+		return nil, err
+	}
+	return sk.Sign(b), nil
+}
+
+// SignDetached signs bytes with an EdX25519 key in the keyring, returning a
+// detached signature.
+// Requires Unlock.
+func (k *Keyring) SignDetached(kid keys.ID, b []byte) ([]byte, error) {
+	sk, err := k.edx25519Key(kid)
+	if err != nil {
+		return nil, err
+	}
+	return sk.SignDetached(b), nil
+}
+
+// BoxOpen decrypts bytes from sender with a X25519 key in the keyring.
+// Requires Unlock.
+func (k *Keyring) BoxOpen(kid keys.ID, encrypted []byte, sender *keys.X25519PublicKey) ([]byte, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	key, err := getKey(k.db, kid, k.filter())
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, keys.NewErrNotFound(kid.String())
+	}
+	bk := key.AsX25519()
+	if bk == nil {
+		return nil, errors.Errorf("key %s is not a X25519 key", kid)
+	}
+	if err := k.usedWith(AuditKeyUse, "decrypt", key); err != nil {
+		return nil, err
+	}
+	return keys.BoxOpen(encrypted, sender, bk)
+}
+
+func (k *Keyring) edx25519Key(kid keys.ID) (*keys.EdX25519Key, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	key, err := getKey(k.db, kid, k.filter())
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, keys.NewErrNotFound(kid.String())
+	}
+	sk := key.AsEdX25519()
+	if sk == nil {
+		return nil, errors.Errorf("key %s is not an EdX25519 key", kid)
+	}
+	if err := k.usedWith(AuditKeyUse, "sign", key); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
@@ -30,6 +30,18 @@ func initTables(db *sqlx.DB) error {
 			key TEXT PRIMARY KEY NOT NULL,
 			value TEXT NOT NULL
 		);`,
+		`CREATE TABLE IF NOT EXISTS audit (
+			seq INTEGER PRIMARY KEY NOT NULL,
+			ts INTEGER NOT NULL,
+			op TEXT NOT NULL,
+			kid TEXT,
+			authID TEXT,
+			authType TEXT,
+			actor TEXT,
+			detail TEXT,
+			prev BLOB,
+			hash BLOB NOT NULL
+		);`,
 		// TODO: Indexes
 	}
 	for _, stmt := range stmts {
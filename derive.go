@@ -0,0 +1,248 @@
+package keyring
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/api"
+	"github.com/keys-pub/keys/encoding"
+	"github.com/pkg/errors"
+)
+
+// ErrNoSeed if no seed was generated (or restored).
+var ErrNoSeed = errors.New("no seed")
+
+// extDerivationPath is the key extension field for the derivation path.
+const extDerivationPath = "derivationPath"
+
+// Derivation describes a derived key.
+// Derivations aren't secret, and are included in the seed backup (see
+// SeedBackup) to regenerate derived keys with RestoreSeed.
+type Derivation struct {
+	Path string       `json:"path" msgpack:"path"`
+	Type keys.KeyType `json:"type" msgpack:"type"`
+}
+
+// GenerateSeed generates a seed for deriving keys.
+// Returns an error if a seed already exists.
+// Requires Unlock.
+func (k *Keyring) GenerateSeed() error {
+	if k.db == nil {
+		return ErrLocked
+	}
+	return k.setSeed(keys.Rand32()[:])
+}
+
+// HasSeed returns true if a seed exists.
+// Requires Unlock.
+func (k *Keyring) HasSeed() (bool, error) {
+	if k.db == nil {
+		return false, ErrLocked
+	}
+	seed, err := getConfigBytes(k.db, "seed")
+	if err != nil {
+		return false, err
+	}
+	return seed != nil, nil
+}
+
+// SeedPhrase returns the seed as a (BIP39) phrase, for backup.
+// Requires Unlock.
+func (k *Keyring) SeedPhrase() (string, error) {
+	seed, err := k.seed()
+	if err != nil {
+		return "", err
+	}
+	return encoding.BytesToPhrase(seed[:])
+}
+
+// SeedBackup returns the seed phrase, followed by the derivations of derived
+// keys in the keyring (one per line, type then path), for backup.
+// The backup alone restores the seed and derived keys (see RestoreSeed); it
+// should be renewed after deriving a key with a new path.
+// Requires Unlock.
+func (k *Keyring) SeedBackup() (string, error) {
+	phrase, err := k.SeedPhrase()
+	if err != nil {
+		return "", err
+	}
+	ds, err := k.Derivations()
+	if err != nil {
+		return "", err
+	}
+	lines := []string{phrase}
+	for _, d := range ds {
+		lines = append(lines, string(d.Type)+" "+d.Path)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Derive a key from the seed by path.
+// The same seed, path and type always derive the same key.
+// The derived key is saved, with its derivation path in the key extension.
+// Requires Unlock.
+func (k *Keyring) Derive(path string, typ keys.KeyType) (*api.Key, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	seed, err := k.seed()
+	if err != nil {
+		return nil, err
+	}
+	return k.derive(seed, &Derivation{Path: path, Type: typ})
+}
+
+// Derivations returns the derivations for derived keys in the keyring.
+// Requires Unlock.
+func (k *Keyring) Derivations() ([]*Derivation, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	ks, err := getKeys(k.db, "")
+	if err != nil {
+		return nil, err
+	}
+	out := []*Derivation{}
+	for _, key := range ks {
+		path := key.ExtString(extDerivationPath)
+		if path == "" {
+			continue
+		}
+		out = append(out, &Derivation{Path: path, Type: keys.KeyType(key.Type)})
+	}
+	return out, nil
+}
+
+// RestoreSeed restores a seed from a seed backup (see SeedBackup) and
+// regenerates the derived keys in it.
+// The backup can also be the seed phrase only (see SeedPhrase), in which case
+// keys can be regenerated with Derive.
+// Returns an error if a different seed already exists.
+// Requires Unlock.
+func (k *Keyring) RestoreSeed(backup string) ([]*api.Key, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	phrase, ds, err := parseSeedBackup(backup)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := encoding.PhraseToBytes(phrase, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid seed phrase")
+	}
+	existing, err := getConfigBytes(k.db, "seed")
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		if err := k.setSeed(seed[:]); err != nil {
+			return nil, err
+		}
+	} else if *keys.Bytes32(existing) != *seed {
+		return nil, errors.Errorf("seed already exists")
+	}
+
+	out := make([]*api.Key, 0, len(ds))
+	for _, d := range ds {
+		key, err := k.derive(seed, d)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, key)
+	}
+	return out, nil
+}
+
+// parseSeedBackup parses a seed backup (see SeedBackup) into the seed phrase
+// and derivations.
+func parseSeedBackup(backup string) (string, []*Derivation, error) {
+	lines := strings.Split(strings.TrimSpace(backup), "\n")
+	ds := []*Derivation{}
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return "", nil, errors.Errorf("invalid seed backup")
+		}
+		ds = append(ds, &Derivation{Type: keys.KeyType(fields[0]), Path: fields[1]})
+	}
+	return lines[0], ds, nil
+}
+
+func (k *Keyring) derive(seed *[32]byte, d *Derivation) (*api.Key, error) {
+	if d.Path == "" {
+		return nil, errors.Errorf("empty derivation path")
+	}
+	if strings.ContainsAny(d.Path, "\r\n") || strings.TrimSpace(d.Path) != d.Path {
+		return nil, errors.Errorf("invalid derivation path")
+	}
+	info := []byte("keyring/" + string(d.Type) + "/" + d.Path)
+	b := keys.Bytes32(keys.HKDFSHA256(seed[:], 32, nil, info))
+
+	var gen keys.Key
+	switch d.Type {
+	case keys.EdX25519:
+		gen = keys.NewEdX25519KeyFromSeed(b)
+	case keys.X25519:
+		gen = keys.NewX25519KeyFromSeed(b)
+	default:
+		return nil, errors.Errorf("unsupported key type for derive %s", d.Type)
+	}
+
+	existing, err := getKey(k.db, gen.ID(), "")
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, nil
+	}
+
+	key := api.NewKey(gen).Created(k.clock.NowMillis())
+	key.SetExtString(extDerivationPath, d.Path)
+	logger.Debugf("Derived key %s (%s)", key.ID, d.Path)
+	if err := k.Set(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (k *Keyring) seed() (*[32]byte, error) {
+	if k.db == nil {
+		return nil, ErrLocked
+	}
+	b, err := getConfigBytes(k.db, "seed")
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, ErrNoSeed
+	}
+	if len(b) != 32 {
+		return nil, errors.Errorf("invalid seed")
+	}
+	return keys.Bytes32(b), nil
+}
+
+func (k *Keyring) setSeed(seed []byte) error {
+	return Transact(k.db, func(tx *sqlx.Tx) error {
+		var existing string
+		if err := tx.Get(&existing, "SELECT value FROM config WHERE key = $1", "seed"); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+		if existing != "" {
+			return errors.Errorf("seed already exists")
+		}
+		if err := setConfigBytesTx(tx, "seed", seed); err != nil {
+			return err
+		}
+		return auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: AuditConfig, Actor: k.actor, Detail: "seed"})
+	})
+}
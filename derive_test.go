@@ -0,0 +1,86 @@
+package keyring_test
+
+import (
+	"testing"
+
+	"github.com/getchill-app/keyring"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDerive(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn()
+
+	_, err = kr.Derive("signing/0", keys.EdX25519)
+	require.Equal(t, keyring.ErrNoSeed, err)
+
+	err = kr.GenerateSeed()
+	require.NoError(t, err)
+	err = kr.GenerateSeed()
+	require.EqualError(t, err, "seed already exists")
+
+	sk, err := kr.Derive("signing/0", keys.EdX25519)
+	require.NoError(t, err)
+	require.Equal(t, "signing/0", sk.ExtString("derivationPath"))
+	bk, err := kr.Derive("encrypt/0", keys.X25519)
+	require.NoError(t, err)
+	require.NotNil(t, bk.AsX25519())
+
+	// Same path returns same key
+	sk2, err := kr.Derive("signing/0", keys.EdX25519)
+	require.NoError(t, err)
+	require.Equal(t, sk.ID, sk2.ID)
+
+	// Same path with different type is a different key
+	bk2, err := kr.Derive("signing/0", keys.X25519)
+	require.NoError(t, err)
+	require.NotEqual(t, sk.AsEdX25519().X25519Key().ID(), bk2.ID)
+
+	_, err = kr.Derive("", keys.EdX25519)
+	require.EqualError(t, err, "empty derivation path")
+	_, err = kr.Derive("rsa", keys.RSA)
+	require.EqualError(t, err, "unsupported key type for derive rsa")
+
+	_, err = kr.Derive("signing/1\n", keys.EdX25519)
+	require.EqualError(t, err, "invalid derivation path")
+
+	phrase, err := kr.SeedPhrase()
+	require.NoError(t, err)
+	ds, err := kr.Derivations()
+	require.NoError(t, err)
+	require.Equal(t, 3, len(ds))
+	backup, err := kr.SeedBackup()
+	require.NoError(t, err)
+
+	// Recover on another keyring from seed backup
+	kr2, closeFn2 := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn2()
+
+	out, err := kr2.RestoreSeed(backup)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(out))
+
+	restored, err := kr2.Key(sk.ID)
+	require.NoError(t, err)
+	require.Equal(t, sk.Private, restored.Private)
+	restored, err = kr2.Key(bk.ID)
+	require.NoError(t, err)
+	require.Equal(t, bk.Private, restored.Private)
+
+	_, err = kr2.RestoreSeed(keys.RandPhrase())
+	require.EqualError(t, err, "seed already exists")
+
+	// Recover from seed phrase only, deriving keys again
+	kr3, closeFn3 := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn3()
+
+	out, err = kr3.RestoreSeed(phrase)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(out))
+	sk3, err := kr3.Derive("signing/0", keys.EdX25519)
+	require.NoError(t, err)
+	require.Equal(t, sk.Private, sk3.Private)
+}
@@ -0,0 +1,126 @@
+package keyring
+
+import (
+	"os"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+)
+
+// RegisterDuressPassword adds a duress password, which unlocks a decoy vault
+// (a separate, empty vault) instead of this vault.
+// Unlocking with the duress password looks the same as with any other
+// password (see UnlockWithPassword).
+// If destroy, unlocking with the duress password also removes all other auth
+// methods, so this vault can only be opened with the master key.
+// The decoy vault is a file next to the vault, which every vault has (created
+// with a random key on setup, or on unlock for an earlier version), so it
+// doesn't reveal a duress password.
+// There is one decoy vault, so if there is a duress password, returns
+// auth.ErrDuressRegistered; to replace it, remove it first (see
+// auth.DB.Delete).
+func (k *Keyring) RegisterDuressPassword(mk *[32]byte, password string, destroy bool) (*auth.Auth, error) {
+	if k.db == nil {
+		return nil, ErrLocked
+	}
+	dmk := keys.Rand32()
+	reg, err := k.auth.RegisterDuressPassword(password, mk, dmk, destroy)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.setupDecoy(dmk, reg); err != nil {
+		_ = k.auth.Delete(reg.ID)
+		return nil, err
+	}
+	if err := k.audit(&AuditEntry{Op: AuditRegister}, reg); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+// setupDecoy creates the decoy vault, replacing any previous decoy vault, which
+// looks like a vault setup with the duress password.
+// On setup, the decoy vault is created with a random key (and no auth), so
+// every vault has one.
+func (k *Keyring) setupDecoy(dmk *[32]byte, reg *auth.Auth) error {
+	// Create at a temporary path, and replace the decoy vault when done.
+	path := k.decoyPath() + ".tmp"
+	_ = os.Remove(path)
+	db, err := openDB(path, dmk)
+	if err != nil {
+		return err
+	}
+	if err := initTables(db); err != nil {
+		_ = db.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	entry := &AuditEntry{Op: AuditSetup, Actor: k.actor}
+	if reg != nil {
+		entry.AuthID = reg.ID
+		entry.AuthType = string(reg.Type)
+	}
+	if err := Transact(db, func(tx *sqlx.Tx) error {
+		return auditTx(tx, auditKey(dmk), k.clock, entry)
+	}); err != nil {
+		_ = db.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	if err := db.Close(); err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	return os.Rename(path, k.decoyPath())
+}
+
+// checkDecoy creates the decoy vault for a vault from an earlier version
+// (without one, or with one at the previous path), on unlock.
+// Whether it was created is kept in the vault.
+func (k *Keyring) checkDecoy(db *sqlx.DB) error {
+	created, err := getConfig(db, "decoy")
+	if err != nil {
+		return err
+	}
+	if created == "1" {
+		return nil
+	}
+	legacy := k.path + ".decoy"
+	if _, err := os.Stat(legacy); err == nil {
+		logger.Infof("Moving decoy vault")
+		if err := os.Rename(legacy, k.decoyPath()); err != nil {
+			return err
+		}
+	} else {
+		logger.Infof("Creating decoy vault")
+		if err := k.setupDecoy(keys.Rand32(), nil); err != nil {
+			return err
+		}
+	}
+	return setConfig(db, "decoy", "1")
+}
+
+// openDecoy opens the decoy vault, if the master key is for the decoy vault.
+func (k *Keyring) openDecoy(mk *[32]byte) (*sqlx.DB, error) {
+	path := k.decoyPath()
+	// Don't create a decoy vault that doesn't exist.
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := openDB(path, mk)
+	if err != nil {
+		return nil, err
+	}
+	if err := initTables(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// decoyPath is the path of the decoy vault, a file next to the vault (see
+// setupDecoy), with a neutral name, so it doesn't reveal what it is.
+func (k *Keyring) decoyPath() string {
+	return k.path + ".aux"
+}
@@ -0,0 +1,176 @@
+package keyring_test
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/getchill-app/keyring"
+	"github.com/getchill-app/keyring/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuressPassword(t *testing.T) {
+	var err error
+	dir, err := ioutil.TempDir("", "keyring")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	adb, err := auth.NewDB(filepath.Join(dir, "auth.db"))
+	require.NoError(t, err)
+	defer func() { _ = adb.Close() }()
+	kr := keyring.New(filepath.Join(dir, "keyring.db"), adb)
+
+	mk, err := kr.SetupPassword("testpassword")
+	require.NoError(t, err)
+	sk := keys.GenerateEdX25519Key()
+	err = kr.Set(api.NewKey(sk))
+	require.NoError(t, err)
+	files := dirNames(t, dir)
+	_, err = kr.RegisterDuressPassword(mk, "duresspassword", false)
+	require.NoError(t, err)
+	// Every vault has a decoy vault, so registering doesn't add files.
+	require.Equal(t, files, dirNames(t, dir))
+	_, err = kr.RegisterDuressPassword(mk, "duresspassword2", false)
+	require.Equal(t, auth.ErrDuressRegistered, err)
+	err = kr.Lock()
+	require.NoError(t, err)
+
+	// Decoy vault
+	dmk, err := kr.UnlockWithPassword("duresspassword")
+	require.NoError(t, err)
+	require.NotEqual(t, mk, dmk)
+	require.Equal(t, keyring.Unlocked, kr.Status())
+	ks, err := kr.Keys()
+	require.NoError(t, err)
+	require.Equal(t, 0, len(ks))
+	decoy := keys.GenerateEdX25519Key()
+	err = kr.Set(api.NewKey(decoy))
+	require.NoError(t, err)
+	_, err = kr.VerifyPassword("duresspassword")
+	require.NoError(t, err)
+	err = kr.Lock()
+	require.NoError(t, err)
+
+	out, err := kr.UnlockWithPassword("testpassword")
+	require.NoError(t, err)
+	require.Equal(t, mk, out)
+	ks, err = kr.Keys()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(ks))
+	require.Equal(t, sk.ID(), ks[0].ID)
+	err = kr.Lock()
+	require.NoError(t, err)
+}
+
+func TestDuressPasswordVerify(t *testing.T) {
+	var err error
+	dir, err := ioutil.TempDir("", "keyring")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	adb, err := auth.NewDB(filepath.Join(dir, "auth.db"))
+	require.NoError(t, err)
+	defer func() { _ = adb.Close() }()
+	kr := keyring.New(filepath.Join(dir, "keyring.db"), adb)
+
+	mk, err := kr.SetupPassword("testpassword")
+	require.NoError(t, err)
+	_, err = kr.RegisterDuressPassword(mk, "duresspassword", true)
+	require.NoError(t, err)
+
+	// Verifying the duress password with the vault unlocked doesn't lock or
+	// destroy.
+	_, err = kr.VerifyPassword("duresspassword")
+	require.EqualError(t, err, "invalid auth")
+	require.Equal(t, keyring.Unlocked, kr.Status())
+	auths, err := adb.List()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(auths))
+	_, err = kr.VerifyPassword("testpassword")
+	require.NoError(t, err)
+	err = kr.Lock()
+	require.NoError(t, err)
+
+	out, err := kr.UnlockWithPassword("testpassword")
+	require.NoError(t, err)
+	require.Equal(t, mk, out)
+	err = kr.Lock()
+	require.NoError(t, err)
+}
+
+func TestDuressPasswordEarlierVersion(t *testing.T) {
+	var err error
+	dir, err := ioutil.TempDir("", "keyring")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	adb, err := auth.NewDB(filepath.Join(dir, "auth.db"))
+	require.NoError(t, err)
+	defer func() { _ = adb.Close() }()
+	path := filepath.Join(dir, "keyring.db")
+	kr := keyring.New(path, adb)
+
+	mk, err := kr.SetupPassword("testpassword")
+	require.NoError(t, err)
+	_, err = kr.RegisterDuressPassword(mk, "duresspassword", false)
+	require.NoError(t, err)
+	err = kr.Lock()
+	require.NoError(t, err)
+	files := dirNames(t, dir)
+
+	// As created by an earlier version
+	earlier := func() {
+		sdb, err := sqlx.Open("sqlite3", fmt.Sprintf("%s?_pragma_key=x'%s'&_pragma_cipher_page_size=4096", path, hex.EncodeToString(mk[:])))
+		require.NoError(t, err)
+		defer sdb.Close()
+		_, err = sdb.Exec("DELETE FROM config WHERE key = $1", "decoy")
+		require.NoError(t, err)
+	}
+
+	// Decoy vault at the previous path is moved
+	earlier()
+	err = os.Rename(path+".aux", path+".decoy")
+	require.NoError(t, err)
+	_, err = kr.UnlockWithPassword("testpassword")
+	require.NoError(t, err)
+	require.Equal(t, files, dirNames(t, dir))
+	err = kr.Lock()
+	require.NoError(t, err)
+	_, err = kr.UnlockWithPassword("duresspassword")
+	require.NoError(t, err)
+	err = kr.Lock()
+	require.NoError(t, err)
+
+	// Missing decoy vault is created
+	earlier()
+	err = os.Remove(path + ".aux")
+	require.NoError(t, err)
+	_, err = kr.UnlockWithPassword("testpassword")
+	require.NoError(t, err)
+	require.Equal(t, files, dirNames(t, dir))
+	err = kr.Lock()
+	require.NoError(t, err)
+
+	// Created once
+	err = os.Remove(path + ".aux")
+	require.NoError(t, err)
+	_, err = kr.UnlockWithPassword("testpassword")
+	require.NoError(t, err)
+	require.NotEqual(t, files, dirNames(t, dir))
+	err = kr.Lock()
+	require.NoError(t, err)
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	fis, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names
+}
@@ -45,11 +45,11 @@ func (k *Keyring) SetupFIDO2HMACSecret(ctx context.Context, hs *auth.FIDO2HMACSe
 		return nil, errors.Errorf("no fido2 plugin set")
 	}
 	mk := keys.Rand32()
-	_, err := k.auth.RegisterFIDO2HMACSecret(ctx, k.fido2Plugin, hs, mk, pin)
+	reg, err := k.auth.RegisterFIDO2HMACSecret(ctx, k.fido2Plugin, hs, mk, pin)
 	if err != nil {
 		return nil, err
 	}
-	if err := k.Setup(mk); err != nil {
+	if err := k.setup(mk, reg); err != nil {
 		return nil, err
 	}
 	return mk, nil
@@ -68,17 +68,33 @@ func (k *Keyring) RegisterFIDO2HMACSecret(ctx context.Context, mk *[32]byte, hs
 	if err != nil {
 		return nil, err
 	}
+	if err := k.audit(&AuditEntry{Op: AuditRegister}, reg); err != nil {
+		return nil, err
+	}
 	return reg, nil
 }
 
 // UnlockWithFIDO2HMACSecret opens vault with a FIDO2 hmac-secret.
 func (k *Keyring) UnlockWithFIDO2HMACSecret(ctx context.Context, pin string) (*[32]byte, error) {
-	_, mk, err := k.auth.FIDO2HMACSecret(ctx, k.fido2Plugin, pin)
+	reg, mk, err := k.auth.FIDO2HMACSecret(ctx, k.fido2Plugin, pin)
 	if err != nil {
 		return nil, err
 	}
-	if err := k.Unlock(mk); err != nil {
+	if err := k.unlock(mk, reg); err != nil {
 		return nil, err
 	}
 	return mk, nil
 }
+
+// UnlockWithFIDO2HMACSecretDevice opens vault with a FIDO2 hmac-secret,
+// returning the device used.
+func (k *Keyring) UnlockWithFIDO2HMACSecretDevice(ctx context.Context, pin string) (*[32]byte, *fido2.Device, error) {
+	reg, mk, device, err := k.auth.FIDO2HMACSecretDevice(ctx, k.fido2Plugin, pin)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := k.unlock(mk, reg); err != nil {
+		return nil, nil, err
+	}
+	return mk, device, nil
+}
@@ -0,0 +1,138 @@
+package keyring
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/api"
+	"github.com/pkg/errors"
+)
+
+// DefaultHistoryLimit is the default number of previous versions kept for a
+// key.
+const DefaultHistoryLimit = 10
+
+// KeyVersion is a previous version of a key, saved when the key was replaced.
+type KeyVersion struct {
+	api.Key
+
+	// Version increases each time the key is replaced.
+	Version int `db:"version"`
+	// ReplacedAt is when this version was replaced (in milliseconds).
+	ReplacedAt int64 `db:"replacedAt"`
+}
+
+// SetHistoryLimit sets the number of previous versions kept for each key.
+// If limit is <= 0, all versions are kept.
+func (k *Keyring) SetHistoryLimit(limit int) {
+	k.historyLimit = limit
+}
+
+// HistoryLimit is the number of previous versions kept for each key.
+func (k *Keyring) HistoryLimit() int {
+	return k.historyLimit
+}
+
+// History returns previous versions of a key, most recent first.
+// Requires Unlock.
+func (k *Keyring) History(kid keys.ID) ([]*KeyVersion, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	return getHistory(k.db, kid)
+}
+
+// Revert a key to a previous version.
+// The current version of the key is saved to history.
+// Requires Unlock.
+func (k *Keyring) Revert(kid keys.ID, version int) (*api.Key, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	var out *api.Key
+	if err := Transact(k.db, func(tx *sqlx.Tx) error {
+		kv, err := getVersionTx(tx, kid, version)
+		if err != nil {
+			return err
+		}
+		if kv == nil {
+			return keys.NewErrNotFound(kid.String())
+		}
+		logger.Debugf("Reverting key %s to version %d", kid, version)
+		key := kv.Key
+		key.UpdatedAt = k.clock.NowMillis()
+		if _, err := setKeyTx(tx, &key, k.historyLimit, k.clock.NowMillis()); err != nil {
+			return err
+		}
+		out = &key
+		return auditTx(tx, k.auditKey, k.clock, &AuditEntry{
+			Op:     AuditKeyUpdate,
+			KID:    kid,
+			Actor:  k.actor,
+			Detail: fmt.Sprintf("revert to version %d", version),
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func addHistoryTx(tx *sqlx.Tx, key *api.Key, replacedAt int64) error {
+	var version int
+	if err := tx.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM history WHERE id = $1", key.ID); err != nil {
+		return err
+	}
+	kv := &KeyVersion{
+		Key:        *key,
+		Version:    version + 1,
+		ReplacedAt: replacedAt,
+	}
+	logger.Debugf("Saving key %s version %d", key.ID, kv.Version)
+	if _, err := tx.NamedExec(`INSERT INTO history VALUES
+		(:id, :version, :type, :private, :public, :createdAt, :updatedAt, :notes, :labels, :ext, :replacedAt)`, kv); err != nil {
+		return err
+	}
+	return nil
+}
+
+func pruneHistoryTx(tx *sqlx.Tx, kid keys.ID, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	if _, err := tx.Exec(`DELETE FROM history WHERE id = $1 AND version NOT IN
+		(SELECT version FROM history WHERE id = $1 ORDER BY version DESC LIMIT $2)`, kid, limit); err != nil {
+		return err
+	}
+	return nil
+}
+
+func deleteHistoryTx(tx *sqlx.Tx, kid keys.ID) error {
+	if _, err := tx.Exec(`DELETE FROM history WHERE id = ?`, kid); err != nil {
+		return err
+	}
+	return nil
+}
+
+func getVersionTx(tx *sqlx.Tx, kid keys.ID, version int) (*KeyVersion, error) {
+	var kv KeyVersion
+	if err := tx.Get(&kv, "SELECT * FROM history WHERE id = $1 AND version = $2", kid, version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &kv, nil
+}
+
+func getHistory(db *sqlx.DB, kid keys.ID) ([]*KeyVersion, error) {
+	var out []*KeyVersion
+	if err := db.Select(&out, "SELECT * FROM history WHERE id = $1 ORDER BY version DESC", kid); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,85 @@
+package keyring_test
+
+import (
+	"testing"
+
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn()
+
+	sk := keys.GenerateEdX25519Key()
+	key := api.NewKey(sk).WithLabels("v1").Created(1)
+	err = kr.Set(key)
+	require.NoError(t, err)
+
+	versions, err := kr.History(sk.ID())
+	require.NoError(t, err)
+	require.Equal(t, 0, len(versions))
+
+	key2 := api.NewKey(sk).WithLabels("v2").WithNotes("notes2").Created(2)
+	err = kr.Set(key2)
+	require.NoError(t, err)
+	key3 := api.NewKey(sk).WithLabels("v3").Created(3)
+	err = kr.Set(key3)
+	require.NoError(t, err)
+
+	versions, err = kr.History(sk.ID())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(versions))
+	require.Equal(t, 2, versions[0].Version)
+	require.Equal(t, []string{"v2"}, []string(versions[0].Labels))
+	require.Equal(t, "notes2", versions[0].Notes)
+	require.Equal(t, 1, versions[1].Version)
+	require.Equal(t, []string{"v1"}, []string(versions[1].Labels))
+	require.Equal(t, sk.Private(), versions[1].Private)
+
+	out, err := kr.Revert(sk.ID(), 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1"}, []string(out.Labels))
+
+	got, err := kr.Key(sk.ID())
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1"}, []string(got.Labels))
+
+	versions, err = kr.History(sk.ID())
+	require.NoError(t, err)
+	require.Equal(t, 3, len(versions))
+	require.Equal(t, []string{"v3"}, []string(versions[0].Labels))
+
+	_, err = kr.Revert(sk.ID(), 10)
+	require.EqualError(t, err, sk.ID().String()+" not found")
+
+	err = kr.Remove(sk.ID())
+	require.NoError(t, err)
+	versions, err = kr.History(sk.ID())
+	require.NoError(t, err)
+	require.Equal(t, 0, len(versions))
+}
+
+func TestHistoryLimit(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn()
+
+	kr.SetHistoryLimit(2)
+
+	sk := keys.GenerateEdX25519Key()
+	for i := 1; i <= 5; i++ {
+		err = kr.Set(api.NewKey(sk).Created(int64(i)))
+		require.NoError(t, err)
+	}
+
+	versions, err := kr.History(sk.ID())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(versions))
+	require.Equal(t, 4, versions[0].Version)
+	require.Equal(t, int64(4), versions[0].CreatedAt)
+	require.Equal(t, 3, versions[1].Version)
+}
@@ -0,0 +1,61 @@
+package keyring
+
+import (
+	"io"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/keys-pub/keys"
+)
+
+// SetupKeyFile setup vault with a key file.
+func (k *Keyring) SetupKeyFile(path string) (*[32]byte, error) {
+	mk := keys.Rand32()
+	reg, err := k.auth.RegisterKeyFilePath(path, mk)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.setup(mk, reg); err != nil {
+		return nil, err
+	}
+	return mk, nil
+}
+
+// RegisterKeyFile adds a key file.
+func (k *Keyring) RegisterKeyFile(mk *[32]byte, path string) (*auth.Auth, error) {
+	if k.db == nil {
+		return nil, ErrLocked
+	}
+	reg, err := k.auth.RegisterKeyFilePath(path, mk)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.audit(&AuditEntry{Op: AuditRegister}, reg); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+// UnlockWithKeyFile opens vault with a key file at path.
+// If the key file is accessible by other users, a warning is logged.
+func (k *Keyring) UnlockWithKeyFile(path string) (*[32]byte, error) {
+	reg, mk, err := k.auth.KeyFilePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.unlock(mk, reg); err != nil {
+		return nil, err
+	}
+	return mk, nil
+}
+
+// UnlockWithKeyFileReader opens vault with a key file from a reader.
+func (k *Keyring) UnlockWithKeyFileReader(r io.Reader) (*[32]byte, error) {
+	reg, mk, err := k.auth.KeyFile(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.unlock(mk, reg); err != nil {
+		return nil, err
+	}
+	return mk, nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/keys-pub/keys"
 	"github.com/keys-pub/keys-ext/auth/fido2"
 	"github.com/keys-pub/keys/api"
+	"github.com/keys-pub/keys/tsutil"
 
 	"github.com/pkg/errors"
 )
@@ -28,15 +29,30 @@ type Keyring struct {
 	db   *sqlx.DB
 
 	auth *auth.DB
+	// mkCheck is derived from the master key of the open vault (see Verify...).
+	mkCheck []byte
+	// auditKey is derived from the master key of the open vault, for the audit
+	// log MACs.
+	auditKey *[32]byte
 
 	fido2Plugin fido2.FIDO2Server
+
+	historyLimit int
+	actor        string
+	clock        tsutil.Clock
+
+	purge     *purgeOptions
+	purgeStop chan struct{}
+	purgeDone chan struct{}
 }
 
 // New vault.
 func New(path string, auth *auth.DB) *Keyring {
 	kr := &Keyring{
-		path: path,
-		auth: auth,
+		path:         path,
+		auth:         auth,
+		historyLimit: DefaultHistoryLimit,
+		clock:        tsutil.NewClock(),
 	}
 	return kr
 }
@@ -70,6 +86,10 @@ func (k *Keyring) Status() Status {
 // Setup vault.
 // Doesn't unlock.
 func (k *Keyring) Setup(mk *[32]byte) error {
+	return k.setup(mk, nil)
+}
+
+func (k *Keyring) setup(mk *[32]byte, reg *auth.Auth) error {
 	logger.Debugf("Setup...")
 	if k.db != nil {
 		return errors.Errorf("already unlocked")
@@ -92,8 +112,31 @@ func (k *Keyring) Setup(mk *[32]byte) error {
 		onErrFn()
 		return err
 	}
+	if err := k.checkAuthIndex(db); err != nil {
+		onErrFn()
+		return err
+	}
+	// Every vault has a decoy vault, with a random key until a duress password
+	// is registered (see RegisterDuressPassword).
+	if err := k.setupDecoy(keys.Rand32(), nil); err != nil {
+		onErrFn()
+		return err
+	}
+	if err := setConfig(db, "decoy", "1"); err != nil {
+		onErrFn()
+		return err
+	}
+
+	// Audit before the vault is open, so if it fails, it isn't left open.
+	if err := k.auditDB(db, auditKey(mk), &AuditEntry{Op: AuditSetup}, reg); err != nil {
+		onErrFn()
+		return err
+	}
 
 	k.db = db
+	k.mkCheck = masterKeyCheck(mk)
+	k.auditKey = auditKey(mk)
+	k.startPurge()
 
 	logger.Debugf("Setup complete")
 	return nil
@@ -101,6 +144,10 @@ func (k *Keyring) Setup(mk *[32]byte) error {
 
 // Unlock vault.
 func (k *Keyring) Unlock(mk *[32]byte) error {
+	return k.unlock(mk, nil)
+}
+
+func (k *Keyring) unlock(mk *[32]byte, reg *auth.Auth) error {
 	logger.Debugf("Unlock...")
 
 	if k.db != nil {
@@ -116,21 +163,92 @@ func (k *Keyring) Unlock(mk *[32]byte) error {
 	if err != nil {
 		return err
 	}
+	if err := initTables(db); err != nil {
+		_ = db.Close()
+		// The master key may be for the decoy vault (see
+		// RegisterDuressPassword).
+		decoy, derr := k.openDecoy(mk)
+		if derr != nil {
+			return err
+		}
+		db = decoy
+	} else if err := k.checkDecoy(db); err != nil {
+		_ = db.Close()
+		return err
+	}
 	onErrFn := func() {
 		_ = db.Close()
 	}
 
-	if err := initTables(db); err != nil {
+	if err := k.checkAuthIndex(db); err != nil {
+		onErrFn()
+		return err
+	}
+
+	// Audit before the vault is open, so if it fails, it isn't left open.
+	if err := k.auditDB(db, auditKey(mk), &AuditEntry{Op: AuditUnlock}, reg); err != nil {
 		onErrFn()
 		return err
 	}
 
 	k.db = db
+	k.mkCheck = masterKeyCheck(mk)
+	k.auditKey = auditKey(mk)
+	k.startPurge()
 
 	logger.Debugf("Unlocked")
 	return nil
 }
 
+// checkAuthIndex compares the auth index ID with the ID kept in the vault
+// (see auth.DB.IndexID), so an auth index that was removed (and re-created on
+// unlock) is detected, returning auth.ErrTampered.
+// The ID is kept on setup, or the first unlock with an auth method.
+// The multi-factor policy (see SetMultiFactorRequired) is also kept in the
+// vault, so an auth db replaced with one from before multi-factor auth was
+// required is detected.
+func (k *Keyring) checkAuthIndex(db *sqlx.DB) error {
+	if k.auth == nil {
+		return nil
+	}
+	id, err := k.auth.IndexID()
+	if err != nil {
+		if errors.Is(err, auth.ErrLocked) {
+			// Unlocked with a master key, not an auth method.
+			return nil
+		}
+		return err
+	}
+	existing, err := getConfig(db, "authIndexID")
+	if err != nil {
+		return err
+	}
+	if existing == "" {
+		if err := setConfig(db, "authIndexID", id); err != nil {
+			return err
+		}
+	} else if existing != id {
+		k.auth.Lock()
+		return auth.ErrTampered{Verification: &auth.Verification{IndexMissing: true}}
+	}
+	required, err := k.auth.MultiFactorRequired()
+	if err != nil {
+		return err
+	}
+	vaultRequired, err := getConfig(db, "multiFactorRequired")
+	if err != nil {
+		return err
+	}
+	if vaultRequired == "1" && !required {
+		k.auth.Lock()
+		return auth.ErrTampered{Verification: &auth.Verification{PolicyChanged: true}}
+	}
+	if required && vaultRequired != "1" {
+		return setConfig(db, "multiFactorRequired", "1")
+	}
+	return nil
+}
+
 // Lock vault.
 func (k *Keyring) Lock() error {
 	logger.Debugf("Locking...")
@@ -139,8 +257,15 @@ func (k *Keyring) Lock() error {
 		logger.Debugf("Already locked")
 		return nil
 	}
+	if err := k.audit(&AuditEntry{Op: AuditLock}, nil); err != nil {
+		logger.Warningf("Failed to audit lock: %v", err)
+	}
+	k.stopPurge()
+	k.auth.Lock()
 	db := k.db
 	k.db = nil
+	k.mkCheck = nil
+	k.auditKey = nil
 
 	if err := db.Close(); err != nil {
 		return errors.Wrapf(err, "failed to close db")
@@ -175,6 +300,60 @@ func (k *Keyring) initTables() error {
 			labels TEXT,
 			ext JSON
 		);`,
+		`CREATE TABLE IF NOT EXISTS history (
+			id TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			private BLOB,
+			public BLOB,
+			createdAt INTEGER,
+			updatedAt INTEGER,
+			notes TEXT,
+			labels TEXT,
+			ext JSON,
+			replacedAt INTEGER,
+			PRIMARY KEY (id, version)
+		);`,
+		`CREATE TABLE IF NOT EXISTS usage (
+			id TEXT PRIMARY KEY NOT NULL,
+			lastUsedAt INTEGER,
+			uses INTEGER
+		);`,
+		`CREATE TABLE IF NOT EXISTS validity (
+			id TEXT PRIMARY KEY NOT NULL,
+			notBefore INTEGER,
+			expiresAt INTEGER
+		);`,
+		`CREATE TABLE IF NOT EXISTS retired (
+			id TEXT PRIMARY KEY NOT NULL,
+			successor TEXT NOT NULL,
+			retiredAt INTEGER
+		);`,
+		`CREATE TABLE IF NOT EXISTS secrets (
+			id TEXT PRIMARY KEY NOT NULL,
+			name TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			fields JSON,
+			labels TEXT,
+			notes TEXT,
+			createdAt INTEGER,
+			updatedAt INTEGER
+		);`,
+		`CREATE TABLE IF NOT EXISTS blobs (
+			name TEXT PRIMARY KEY NOT NULL,
+			size INTEGER NOT NULL,
+			chunks INTEGER NOT NULL,
+			hash BLOB NOT NULL,
+			kid TEXT,
+			labels TEXT,
+			createdAt INTEGER
+		);`,
+		`CREATE TABLE IF NOT EXISTS blobChunks (
+			name TEXT NOT NULL,
+			idx INTEGER NOT NULL,
+			data BLOB NOT NULL,
+			PRIMARY KEY (name, idx)
+		);`,
 		// TODO: Indexes
 	}
 	for _, stmt := range stmts {
@@ -196,6 +375,7 @@ func (k *Keyring) initDB() error {
 }
 
 // Set a key in the Keyring.
+// If a key with the same ID exists, the previous version is saved to history.
 // Requires Unlock.
 func (k *Keyring) Set(key *api.Key) error {
 	if err := k.initDB(); err != nil {
@@ -203,54 +383,95 @@ func (k *Keyring) Set(key *api.Key) error {
 	}
 	return Transact(k.db, func(tx *sqlx.Tx) error {
 		logger.Debugf("Saving key %s", key.ID)
-		if err := updateKeyTx(tx, key); err != nil {
+		replaced, err := setKeyTx(tx, key, k.historyLimit, k.clock.NowMillis())
+		if err != nil {
 			return err
 		}
-		return nil
+		op := AuditKeyCreate
+		if replaced {
+			op = AuditKeyUpdate
+		}
+		return auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: op, KID: key.ID, Actor: k.actor})
 	})
 }
 
-// Remove a key.
+// Remove a key (and its history).
 // Requires Unlock.
 func (k *Keyring) Remove(kid keys.ID) error {
 	if err := k.initDB(); err != nil {
 		return err
 	}
 	return Transact(k.db, func(tx *sqlx.Tx) error {
-		return deleteKeyTx(tx, kid)
+		if err := removeKeyTx(tx, kid); err != nil {
+			return err
+		}
+		return auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: AuditKeyRemove, KID: kid, Actor: k.actor})
 	})
 }
 
 // Keys in vault.
-func (k *Keyring) Keys() ([]*api.Key, error) {
-	if k.db == nil {
-		return nil, ErrLocked
+// Listing keys isn't counted as a use (see KeyInfo), but keys with private key
+// material are audited (AuditKeyRead).
+// Keys outside their validity window are excluded, see IncludeExpired.
+func (k *Keyring) Keys(opt ...LookupOption) ([]*api.Key, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	ks, err := getKeys(k.db, k.filter(opt...))
+	if err != nil {
+		return nil, err
+	}
+	if err := k.listed(ks...); err != nil {
+		return nil, err
 	}
-	return getKeys(k.db)
+	return ks, nil
 }
 
 // KeysWithType in vault.
-func (k *Keyring) KeysWithType(typ string) ([]*api.Key, error) {
+// Listing keys isn't counted as a use (see KeyInfo), but keys with private key
+// material are audited (AuditKeyRead).
+// Keys outside their validity window are excluded, see IncludeExpired.
+func (k *Keyring) KeysWithType(typ string, opt ...LookupOption) ([]*api.Key, error) {
 	if err := k.initDB(); err != nil {
 		return nil, err
 	}
-	return getKeysByType(k.db, typ)
+	ks, err := getKeysByType(k.db, typ, k.filter(opt...))
+	if err != nil {
+		return nil, err
+	}
+	if err := k.listed(ks...); err != nil {
+		return nil, err
+	}
+	return ks, nil
 }
 
 // KeysWithLabel in vault.
-func (k *Keyring) KeysWithLabel(label string) ([]*api.Key, error) {
+// Listing keys isn't counted as a use (see KeyInfo), but keys with private key
+// material are audited (AuditKeyRead).
+// Keys outside their validity window are excluded, see IncludeExpired.
+// Retired keys are excluded, see AllVersions.
+func (k *Keyring) KeysWithLabel(label string, opt ...LookupOption) ([]*api.Key, error) {
 	if err := k.initDB(); err != nil {
 		return nil, err
 	}
-	return getKeysByLabel(k.db, label)
+	ks, err := getKeysByLabel(k.db, label, k.labelFilter(opt...))
+	if err != nil {
+		return nil, err
+	}
+	if err := k.listed(ks...); err != nil {
+		return nil, err
+	}
+	return ks, nil
 }
 
 // KeyWithLabel in vault.
-func (k *Keyring) KeyWithLabel(label string) (*api.Key, error) {
+// Keys outside their validity window are excluded, see IncludeExpired.
+// Retired keys are excluded, see AllVersions.
+func (k *Keyring) KeyWithLabel(label string, opt ...LookupOption) (*api.Key, error) {
 	if err := k.initDB(); err != nil {
 		return nil, err
 	}
-	ks, err := getKeysByLabel(k.db, label)
+	ks, err := getKeysByLabel(k.db, label, k.labelFilter(opt...))
 	if err != nil {
 		return nil, err
 	}
@@ -260,26 +481,37 @@ func (k *Keyring) KeyWithLabel(label string) (*api.Key, error) {
 	if len(ks) > 1 {
 		return nil, errors.Errorf("multiple keys for label %q", label)
 	}
+	if err := k.used(ks[0]); err != nil {
+		return nil, err
+	}
 	return ks[0], nil
 }
 
 // Get key by id.
 // Returns nil if not found.
-func (k *Keyring) Get(kid keys.ID) (*api.Key, error) {
+// Keys outside their validity window are excluded, see IncludeExpired.
+func (k *Keyring) Get(kid keys.ID, opt ...LookupOption) (*api.Key, error) {
 	if err := k.initDB(); err != nil {
 		return nil, err
 	}
-	return getKey(k.db, kid)
+	key, err := getKey(k.db, kid, k.filter(opt...))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, nil
+	}
+	if err := k.used(key); err != nil {
+		return nil, err
+	}
+	return key, nil
 }
 
 // Key by id.
 // If not found, returns keys.ErrNotFound.
 // You can use Get instead.
-func (k *Keyring) Key(kid keys.ID) (*api.Key, error) {
-	if err := k.initDB(); err != nil {
-		return nil, err
-	}
-	key, err := getKey(k.db, kid)
+func (k *Keyring) Key(kid keys.ID, opt ...LookupOption) (*api.Key, error) {
+	key, err := k.Get(kid, opt...)
 	if err != nil {
 		return nil, err
 	}
@@ -289,6 +521,27 @@ func (k *Keyring) Key(kid keys.ID) (*api.Key, error) {
 	return key, nil
 }
 
+// setKeyTx saves a key, returning true if it replaced an existing key (saved to
+// history, replaced at ts).
+func setKeyTx(tx *sqlx.Tx, key *api.Key, historyLimit int, ts int64) (bool, error) {
+	existing, err := getKeyTx(tx, key.ID)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		if err := addHistoryTx(tx, existing, ts); err != nil {
+			return false, err
+		}
+		if err := pruneHistoryTx(tx, key.ID, historyLimit); err != nil {
+			return false, err
+		}
+	}
+	if err := updateKeyTx(tx, key); err != nil {
+		return false, err
+	}
+	return existing != nil, nil
+}
+
 func updateKeyTx(tx *sqlx.Tx, key *api.Key) error {
 	logger.Debugf("Update key %s", key.ID)
 	if _, err := tx.NamedExec(`INSERT OR REPLACE INTO keys VALUES 
@@ -298,6 +551,26 @@ func updateKeyTx(tx *sqlx.Tx, key *api.Key) error {
 	return nil
 }
 
+// removeKeyTx deletes a key and anything stored about it.
+func removeKeyTx(tx *sqlx.Tx, kid keys.ID) error {
+	if err := deleteKeyTx(tx, kid); err != nil {
+		return err
+	}
+	if err := deleteHistoryTx(tx, kid); err != nil {
+		return err
+	}
+	if err := deleteUsageTx(tx, kid); err != nil {
+		return err
+	}
+	if err := deleteValidityTx(tx, kid); err != nil {
+		return err
+	}
+	if err := deleteRetiredTx(tx, kid); err != nil {
+		return err
+	}
+	return unlinkBlobsTx(tx, kid)
+}
+
 func deleteKeyTx(tx *sqlx.Tx, kid keys.ID) error {
 	if kid == "" {
 		return errors.Errorf("failed to delete key: empty id")
@@ -309,9 +582,20 @@ func deleteKeyTx(tx *sqlx.Tx, kid keys.ID) error {
 	return nil
 }
 
-func getKey(db *sqlx.DB, kid keys.ID) (*api.Key, error) {
+func getKey(db *sqlx.DB, kid keys.ID, filter string) (*api.Key, error) {
+	var key api.Key
+	if err := db.Get(&key, "SELECT * FROM keys WHERE id = $1"+filter, kid); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+func getKeyTx(tx *sqlx.Tx, kid keys.ID) (*api.Key, error) {
 	var key api.Key
-	if err := db.Get(&key, "SELECT * FROM keys WHERE id = $1", kid); err != nil {
+	if err := tx.Get(&key, "SELECT * FROM keys WHERE id = $1", kid); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -320,9 +604,9 @@ func getKey(db *sqlx.DB, kid keys.ID) (*api.Key, error) {
 	return &key, nil
 }
 
-func getKeys(db *sqlx.DB) ([]*api.Key, error) {
+func getKeys(db *sqlx.DB, filter string) ([]*api.Key, error) {
 	var vks []*api.Key
-	if err := db.Select(&vks, "SELECT * FROM keys ORDER BY id"); err != nil {
+	if err := db.Select(&vks, "SELECT * FROM keys WHERE 1"+filter+" ORDER BY id"); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -331,9 +615,9 @@ func getKeys(db *sqlx.DB) ([]*api.Key, error) {
 	return vks, nil
 }
 
-func getKeysByType(db *sqlx.DB, typ string) ([]*api.Key, error) {
+func getKeysByType(db *sqlx.DB, typ string, filter string) ([]*api.Key, error) {
 	var vks []*api.Key
-	if err := db.Select(&vks, "SELECT * FROM keys WHERE type = $1 ORDER BY id", typ); err != nil {
+	if err := db.Select(&vks, "SELECT * FROM keys WHERE type = $1"+filter+" ORDER BY id", typ); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -342,11 +626,11 @@ func getKeysByType(db *sqlx.DB, typ string) ([]*api.Key, error) {
 	return vks, nil
 }
 
-func getKeysByLabel(db *sqlx.DB, label string) ([]*api.Key, error) {
+func getKeysByLabel(db *sqlx.DB, label string, filter string) ([]*api.Key, error) {
 	logger.Debugf("Get keys with label %q", label)
 	var out []*api.Key
 	sqlLabel := "%^" + label + "$%"
-	if err := db.Select(&out, "SELECT * FROM keys WHERE labels LIKE $1", sqlLabel); err != nil {
+	if err := db.Select(&out, "SELECT * FROM keys WHERE labels LIKE $1"+filter, sqlLabel); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
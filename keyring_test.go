@@ -1,11 +1,18 @@
 package keyring_test
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/getchill-app/keyring"
+	"github.com/getchill-app/keyring/auth"
 	"github.com/getchill-app/keyring/testutil"
+	"github.com/jmoiron/sqlx"
 	"github.com/keys-pub/keys"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -75,3 +82,180 @@ func TestInvalidPassword(t *testing.T) {
 	_, err = kr.UnlockWithPassword("invalidpassword")
 	require.EqualError(t, err, "invalid auth")
 }
+
+func TestX25519(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyring(t)
+	defer closeFn()
+
+	service := keys.GenerateX25519Key()
+	teammate := keys.GenerateX25519Key()
+
+	mk, err := kr.SetupX25519(service.PublicKey())
+	require.NoError(t, err)
+	_, err = kr.RegisterX25519(mk, teammate.PublicKey())
+	require.NoError(t, err)
+	err = kr.Lock()
+	require.NoError(t, err)
+
+	out, err := kr.UnlockWithX25519(teammate)
+	require.NoError(t, err)
+	require.Equal(t, mk, out)
+	err = kr.Lock()
+	require.NoError(t, err)
+
+	out, err = kr.UnlockWithX25519(service)
+	require.NoError(t, err)
+	require.Equal(t, mk, out)
+	err = kr.Lock()
+	require.NoError(t, err)
+
+	_, err = kr.UnlockWithX25519(keys.GenerateX25519Key())
+	require.EqualError(t, err, "invalid auth")
+}
+
+func TestToken(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyring(t)
+	defer closeFn()
+
+	mk, err := kr.SetupPassword("testpassword")
+	require.NoError(t, err)
+	_, token, err := kr.RegisterToken(mk, 0)
+	require.NoError(t, err)
+	err = kr.Lock()
+	require.NoError(t, err)
+
+	os.Setenv("TEST_KEYRING_TOKEN", token)
+	defer os.Unsetenv("TEST_KEYRING_TOKEN")
+	out, err := kr.UnlockWithTokenFromEnv("TEST_KEYRING_TOKEN")
+	require.NoError(t, err)
+	require.Equal(t, mk, out)
+
+	err = kr.RevokeToken(token)
+	require.NoError(t, err)
+	err = kr.Lock()
+	require.NoError(t, err)
+
+	_, err = kr.UnlockWithToken(token)
+	require.EqualError(t, err, "invalid auth")
+	_, err = kr.UnlockWithTokenFromEnv("TEST_KEYRING_TOKEN_NOTSET")
+	require.EqualError(t, err, "no token in TEST_KEYRING_TOKEN_NOTSET")
+}
+
+func TestShamir(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyring(t)
+	defer closeFn()
+
+	mk, err := kr.SetupPassword("testpassword")
+	require.NoError(t, err)
+	_, shares, err := kr.RegisterShamir(mk, 2, 3)
+	require.NoError(t, err)
+	err = kr.Lock()
+	require.NoError(t, err)
+
+	recovery := kr.ShamirRecovery()
+	_, err = recovery.Add(shares[1])
+	require.NoError(t, err)
+	remaining, err := recovery.Add(shares[2])
+	require.NoError(t, err)
+	require.Equal(t, 0, remaining)
+	out, err := kr.UnlockWithShamirRecovery(recovery)
+	require.NoError(t, err)
+	require.Equal(t, mk, out)
+}
+
+func TestRecoveryKit(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyring(t)
+	defer closeFn()
+
+	kit := kr.NewRecoveryKit()
+	_, err = kr.SetupRecoveryKit(kit, "")
+	require.EqualError(t, err, "paper key doesn't match")
+	mk, err := kr.SetupRecoveryKit(kit, kit.PaperKey)
+	require.NoError(t, err)
+	err = kr.Lock()
+	require.NoError(t, err)
+
+	out, err := kr.UnlockWithPaperKey(kit.PaperKey)
+	require.NoError(t, err)
+	require.Equal(t, mk, out)
+}
+
+func TestAuthIndexRemoved(t *testing.T) {
+	var err error
+	dir, err := ioutil.TempDir("", "keyring")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	authPath := filepath.Join(dir, "auth.db")
+	adb, err := auth.NewDB(authPath)
+	require.NoError(t, err)
+	defer func() { _ = adb.Close() }()
+	kr := keyring.New(filepath.Join(dir, "keyring.db"), adb)
+
+	_, err = kr.SetupPassword("testpassword")
+	require.NoError(t, err)
+	err = kr.Lock()
+	require.NoError(t, err)
+	_, err = kr.UnlockWithPassword("testpassword")
+	require.NoError(t, err)
+	err = kr.Lock()
+	require.NoError(t, err)
+
+	// Remove the auth index outside of the library
+	sdb, err := sqlx.Open("sqlite3", authPath)
+	require.NoError(t, err)
+	defer sdb.Close()
+	_, err = sdb.Exec("DELETE FROM config WHERE key IN ('authIndex', 'authIndex2')")
+	require.NoError(t, err)
+
+	// The re-created index isn't trusted
+	for i := 0; i < 2; i++ {
+		_, err = kr.UnlockWithPassword("testpassword")
+		var tampered auth.ErrTampered
+		require.True(t, errors.As(err, &tampered))
+		require.True(t, tampered.IndexMissing)
+		require.Equal(t, keyring.Locked, kr.Status())
+	}
+}
+
+func TestMultiFactorPolicyRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	authPath := filepath.Join(dir, "auth.db")
+	adb, err := auth.NewDB(authPath)
+	require.NoError(t, err)
+	kr := keyring.New(filepath.Join(dir, "keyring.db"), adb)
+
+	mk, err := kr.SetupPassword("testpassword")
+	require.NoError(t, err)
+	_, err = kr.UnlockWithPassword("testpassword")
+	require.NoError(t, err)
+	_, err = kr.RegisterMultiFactor(context.TODO(), mk,
+		auth.PasswordFactor("testpassword2"),
+		auth.PaperKeyFactor(keys.RandPhrase()))
+	require.NoError(t, err)
+
+	// Keep a copy of the auth db from before multi-factor auth was required
+	backup, err := ioutil.ReadFile(authPath)
+	require.NoError(t, err)
+	require.NoError(t, kr.SetMultiFactorRequired(true))
+	require.NoError(t, kr.Lock())
+	require.NoError(t, adb.Close())
+
+	// Restore it outside of the library
+	require.NoError(t, ioutil.WriteFile(authPath, backup, 0600))
+	adb, err = auth.NewDB(authPath)
+	require.NoError(t, err)
+	defer func() { _ = adb.Close() }()
+	kr = keyring.New(filepath.Join(dir, "keyring.db"), adb)
+
+	_, err = kr.UnlockWithPassword("testpassword")
+	var tampered auth.ErrTampered
+	require.True(t, errors.As(err, &tampered))
+	require.True(t, tampered.PolicyChanged)
+	require.Equal(t, keyring.Locked, kr.Status())
+}
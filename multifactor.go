@@ -0,0 +1,54 @@
+package keyring
+
+import (
+	"context"
+
+	"github.com/getchill-app/keyring/auth"
+)
+
+// RegisterMultiFactor adds multi-factor auth, requiring all the factors
+// together to unlock.
+// A FIDO2 hmac-secret factor requires the FIDO2 plugin (see SetFIDO2Plugin).
+func (k *Keyring) RegisterMultiFactor(ctx context.Context, mk *[32]byte, factors ...*auth.FactorSecret) (*auth.Auth, error) {
+	if k.db == nil {
+		return nil, ErrLocked
+	}
+	reg, err := k.auth.RegisterMultiFactor(ctx, k.fido2Plugin, mk, factors...)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.audit(&AuditEntry{Op: AuditRegister}, reg); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+// SetMultiFactorRequired sets whether multi-factor auth is required to unlock.
+// If required, other auth methods are disabled (see
+// auth.DB.SetMultiFactorRequired).
+// The policy is also kept in the vault (see checkAuthIndex).
+func (k *Keyring) SetMultiFactorRequired(required bool) error {
+	if k.db == nil {
+		return ErrLocked
+	}
+	if err := k.auth.SetMultiFactorRequired(required); err != nil {
+		return err
+	}
+	value := ""
+	if required {
+		value = "1"
+	}
+	return setConfig(k.db, "multiFactorRequired", value)
+}
+
+// UnlockWithMultiFactor opens vault with multiple factors.
+func (k *Keyring) UnlockWithMultiFactor(ctx context.Context, factors ...*auth.FactorSecret) (*[32]byte, error) {
+	reg, mk, err := k.auth.MultiFactor(ctx, k.fido2Plugin, factors...)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.unlock(mk, reg); err != nil {
+		return nil, err
+	}
+	return mk, nil
+}
@@ -0,0 +1,243 @@
+package keyring
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" // #nosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/pkg/errors"
+)
+
+// OTPSecret is a secret kind for one-time passwords (TOTP or HOTP).
+const OTPSecret SecretKind = "otp"
+
+// OTP secret fields.
+const (
+	otpType      = "type"
+	otpSecret    = "secret"
+	otpAlgorithm = "algorithm"
+	otpDigits    = "digits"
+	otpPeriod    = "period"
+	otpCounter   = "counter"
+	otpIssuer    = "issuer"
+	otpAccount   = "account"
+)
+
+// ImportOTP adds a one-time password secret from an otpauth:// URI, for
+// example, "otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example".
+// Requires Unlock.
+func (k *Keyring) ImportOTP(uri string) (*Secret, error) {
+	secret, err := parseOTPAuth(uri)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.SetSecret(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// OTP returns the one-time password code for a secret at time t, and for TOTP,
+// the number of seconds the code remains valid.
+// For HOTP, the counter is incremented each time a code is returned and the
+// seconds remaining is 0.
+// Requires Unlock.
+func (k *Keyring) OTP(id string, t time.Time) (string, int, error) {
+	if err := k.initDB(); err != nil {
+		return "", 0, err
+	}
+	var code string
+	var remaining int
+	if err := Transact(k.db, func(tx *sqlx.Tx) error {
+		secret, err := getSecretTx(tx, id)
+		if err != nil {
+			return err
+		}
+		if secret == nil {
+			return keys.NewErrNotFound(id)
+		}
+		if secret.Kind != OTPSecret {
+			return errors.Errorf("secret %s is not an otp", id)
+		}
+		b, err := decodeOTPSecret(secret.Fields[otpSecret])
+		if err != nil {
+			return err
+		}
+		digits, err := otpInt(secret.Fields, otpDigits, 6)
+		if err != nil {
+			return err
+		}
+		alg := secret.Fields[otpAlgorithm]
+
+		switch secret.Fields[otpType] {
+		case "totp":
+			period, err := otpInt(secret.Fields, otpPeriod, 30)
+			if err != nil {
+				return err
+			}
+			if period <= 0 {
+				return errors.Errorf("invalid otp period")
+			}
+			code, err = totp(b, t, period, digits, alg)
+			if err != nil {
+				return err
+			}
+			remaining = period - int(t.Unix()%int64(period))
+		case "hotp":
+			counter, err := otpInt(secret.Fields, otpCounter, 0)
+			if err != nil {
+				return err
+			}
+			code, err = hotp(b, uint64(counter), digits, alg)
+			if err != nil {
+				return err
+			}
+			secret.Fields[otpCounter] = strconv.Itoa(counter + 1)
+			if _, err := tx.Exec(`UPDATE secrets SET fields = $1 WHERE id = $2`, secret.Fields, id); err != nil {
+				return err
+			}
+		default:
+			return errors.Errorf("invalid otp type %q", secret.Fields[otpType])
+		}
+		return auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: AuditSecretRead, KID: keys.ID(id), Actor: k.actor, Detail: "otp"})
+	}); err != nil {
+		return "", 0, err
+	}
+	return code, remaining, nil
+}
+
+func parseOTPAuth(uri string) (*Secret, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid otpauth uri")
+	}
+	if u.Scheme != "otpauth" {
+		return nil, errors.Errorf("invalid otpauth uri scheme %q", u.Scheme)
+	}
+	typ := strings.ToLower(u.Host)
+	if typ != "totp" && typ != "hotp" {
+		return nil, errors.Errorf("invalid otp type %q", u.Host)
+	}
+	q := u.Query()
+	secret := strings.ToUpper(q.Get("secret"))
+	if _, err := decodeOTPSecret(secret); err != nil {
+		return nil, err
+	}
+
+	label := strings.TrimPrefix(u.Path, "/")
+	issuer, account := q.Get("issuer"), label
+	if i := strings.Index(label, ":"); i >= 0 {
+		if issuer == "" {
+			issuer = label[:i]
+		}
+		account = strings.TrimSpace(label[i+1:])
+	}
+	name := account
+	if issuer != "" {
+		name = issuer + ":" + account
+	}
+
+	out := NewSecret(name, OTPSecret).
+		WithField(otpType, typ).
+		WithField(otpSecret, secret).
+		WithField(otpAccount, account)
+	if issuer != "" {
+		out.WithField(otpIssuer, issuer)
+	}
+	if alg := q.Get("algorithm"); alg != "" {
+		if _, err := otpHash(alg); err != nil {
+			return nil, err
+		}
+		out.WithField(otpAlgorithm, strings.ToUpper(alg))
+	}
+	for _, f := range []string{otpDigits, otpPeriod, otpCounter} {
+		if v := q.Get(f); v != "" {
+			if _, err := strconv.Atoi(v); err != nil {
+				return nil, errors.Errorf("invalid otp %s", f)
+			}
+			out.WithField(f, v)
+		}
+	}
+	if typ == "hotp" && out.Fields[otpCounter] == "" {
+		out.WithField(otpCounter, "0")
+	}
+	return out, nil
+}
+
+func decodeOTPSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	b, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, errors.Errorf("invalid otp secret")
+	}
+	if len(b) == 0 {
+		return nil, errors.Errorf("empty otp secret")
+	}
+	return b, nil
+}
+
+func otpInt(fields Fields, name string, defaultValue int) (int, error) {
+	s := fields[name]
+	if s == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.Errorf("invalid otp %s", name)
+	}
+	return n, nil
+}
+
+func otpHash(alg string) (func() hash.Hash, error) {
+	switch strings.ToUpper(alg) {
+	case "", "SHA1":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	default:
+		return nil, errors.Errorf("unsupported otp algorithm %q", alg)
+	}
+}
+
+// totp returns the TOTP code (RFC 6238).
+func totp(secret []byte, t time.Time, period int, digits int, alg string) (string, error) {
+	counter := uint64(t.Unix() / int64(period))
+	return hotp(secret, counter, digits, alg)
+}
+
+// hotp returns the HOTP code (RFC 4226).
+func hotp(secret []byte, counter uint64, digits int, alg string) (string, error) {
+	if digits < 6 || digits > 10 {
+		return "", errors.Errorf("invalid otp digits %d", digits)
+	}
+	h, err := otpHash(alg)
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(h, secret)
+	_, _ = mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint64(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, uint64(bin)%mod), nil
+}
@@ -0,0 +1,114 @@
+package keyring_test
+
+import (
+	"encoding/base32"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/getchill-app/keyring"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPVectors(t *testing.T) {
+	kr, closeFn := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn()
+
+	// RFC 6238 Appendix B
+	seeds := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	vectors := []struct {
+		t    int64
+		alg  string
+		code string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1111111111, "SHA1", "14050471"},
+		{1111111111, "SHA256", "67062674"},
+		{1111111111, "SHA512", "99943326"},
+		{1234567890, "SHA1", "89005924"},
+		{1234567890, "SHA256", "91819424"},
+		{1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"},
+		{2000000000, "SHA256", "90698825"},
+		{2000000000, "SHA512", "38618901"},
+		{20000000000, "SHA1", "65353130"},
+		{20000000000, "SHA256", "77737706"},
+		{20000000000, "SHA512", "47863826"},
+	}
+
+	ids := map[string]string{}
+	for alg, seed := range seeds {
+		secret := base32.StdEncoding.EncodeToString([]byte(seed))
+		uri := fmt.Sprintf("otpauth://totp/Example:alice@example.com?secret=%s&algorithm=%s&digits=8&period=30", secret, alg)
+		out, err := kr.ImportOTP(uri)
+		require.NoError(t, err)
+		require.Equal(t, "Example:alice@example.com", out.Name)
+		ids[alg] = out.ID
+	}
+
+	for _, v := range vectors {
+		code, remaining, err := kr.OTP(ids[v.alg], time.Unix(v.t, 0))
+		require.NoError(t, err)
+		require.Equal(t, v.code, code, "%d %s", v.t, v.alg)
+		require.Equal(t, 30-int(v.t%30), remaining)
+	}
+}
+
+func TestHOTPVectors(t *testing.T) {
+	kr, closeFn := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn()
+
+	// RFC 4226 Appendix D
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	out, err := kr.ImportOTP("otpauth://hotp/alice?secret=" + secret + "&issuer=Example")
+	require.NoError(t, err)
+	require.Equal(t, "Example:alice", out.Name)
+
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for _, e := range expected {
+		code, remaining, err := kr.OTP(out.ID, time.Now())
+		require.NoError(t, err)
+		require.Equal(t, e, code)
+		require.Equal(t, 0, remaining)
+	}
+
+	secret2, err := kr.Secret(out.ID)
+	require.NoError(t, err)
+	require.Equal(t, "10", secret2.Fields["counter"])
+}
+
+func TestImportOTPInvalid(t *testing.T) {
+	kr, closeFn := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn()
+
+	_, err := kr.ImportOTP("https://totp/alice?secret=JBSWY3DPEHPK3PXP")
+	require.EqualError(t, err, `invalid otpauth uri scheme "https"`)
+	_, err = kr.ImportOTP("otpauth://motp/alice?secret=JBSWY3DPEHPK3PXP")
+	require.EqualError(t, err, `invalid otp type "motp"`)
+	_, err = kr.ImportOTP("otpauth://totp/alice?secret=1")
+	require.EqualError(t, err, "invalid otp secret")
+	_, err = kr.ImportOTP("otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&algorithm=MD5")
+	require.EqualError(t, err, `unsupported otp algorithm "MD5"`)
+
+	out, err := kr.ImportOTP("otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	code, _, err := kr.OTP(out.ID, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 6, len(code))
+
+	note := keyring.NewSecret("note", keyring.NoteSecret)
+	err = kr.SetSecret(note)
+	require.NoError(t, err)
+	_, _, err = kr.OTP(note.ID, time.Now())
+	require.EqualError(t, err, "secret "+note.ID+" is not an otp")
+}
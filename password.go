@@ -8,10 +8,11 @@ import (
 // SetupPassword setup vault with a password.
 func (k *Keyring) SetupPassword(password string) (*[32]byte, error) {
 	mk := keys.Rand32()
-	if _, err := k.auth.RegisterPassword(password, mk); err != nil {
+	reg, err := k.auth.RegisterPassword(password, mk)
+	if err != nil {
 		return nil, err
 	}
-	if err := k.Setup(mk); err != nil {
+	if err := k.setup(mk, reg); err != nil {
 		return nil, err
 	}
 	return mk, nil
@@ -26,16 +27,19 @@ func (k *Keyring) RegisterPassword(mk *[32]byte, password string) (*auth.Auth, e
 	if err != nil {
 		return nil, err
 	}
+	if err := k.audit(&AuditEntry{Op: AuditRegister}, reg); err != nil {
+		return nil, err
+	}
 	return reg, nil
 }
 
 // UnlockWithPassword opens vault with a password.
 func (k *Keyring) UnlockWithPassword(password string) (*[32]byte, error) {
-	_, mk, err := k.auth.Password(password)
+	reg, mk, err := k.auth.Password(password)
 	if err != nil {
 		return nil, err
 	}
-	if err := k.Unlock(mk); err != nil {
+	if err := k.unlock(mk, reg); err != nil {
 		return nil, err
 	}
 	return mk, nil
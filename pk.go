@@ -8,11 +8,11 @@ import (
 // SetupPaperKey setup vault with a paper key.
 func (k *Keyring) SetupPaperKey(paperKey string) (*[32]byte, error) {
 	mk := keys.Rand32()
-	_, err := k.auth.RegisterPaperKey(paperKey, mk)
+	reg, err := k.auth.RegisterPaperKey(paperKey, mk)
 	if err != nil {
 		return nil, err
 	}
-	if err := k.Setup(mk); err != nil {
+	if err := k.setup(mk, reg); err != nil {
 		return nil, err
 	}
 	return mk, nil
@@ -27,17 +27,57 @@ func (k *Keyring) RegisterPaperKey(mk *[32]byte, paperKey string) (*auth.Auth, e
 	if err != nil {
 		return nil, err
 	}
+	if err := k.audit(&AuditEntry{Op: AuditRegister}, reg); err != nil {
+		return nil, err
+	}
 	return reg, nil
 }
 
 // UnlockWithPaperKey opens vault with a paper key.
 func (k *Keyring) UnlockWithPaperKey(paperKey string) (*[32]byte, error) {
-	_, mk, err := k.auth.PaperKey(paperKey)
+	reg, mk, err := k.auth.PaperKey(paperKey)
 	if err != nil {
 		return nil, err
 	}
-	if err := k.Unlock(mk); err != nil {
+	if err := k.unlock(mk, reg); err != nil {
 		return nil, err
 	}
 	return mk, nil
 }
+
+// NewRecoveryKit creates a recovery kit with a generated paper key, to write
+// down and register with SetupRecoveryKit or RegisterRecoveryKit, and then
+// print (see auth.RecoveryKit Text and QRCode).
+func (k *Keyring) NewRecoveryKit() *auth.RecoveryKit {
+	return k.auth.NewRecoveryKit()
+}
+
+// SetupRecoveryKit setup vault with a recovery kit paper key, if confirm (the
+// re-entered paper key) matches.
+func (k *Keyring) SetupRecoveryKit(kit *auth.RecoveryKit, confirm string) (*[32]byte, error) {
+	mk := keys.Rand32()
+	reg, err := k.auth.RegisterRecoveryKit(kit, confirm, mk)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.setup(mk, reg); err != nil {
+		return nil, err
+	}
+	return mk, nil
+}
+
+// RegisterRecoveryKit adds a recovery kit paper key, if confirm (the
+// re-entered paper key) matches.
+func (k *Keyring) RegisterRecoveryKit(mk *[32]byte, kit *auth.RecoveryKit, confirm string) (*auth.Auth, error) {
+	if k.db == nil {
+		return nil, ErrLocked
+	}
+	reg, err := k.auth.RegisterRecoveryKit(kit, confirm, mk)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.audit(&AuditEntry{Op: AuditRegister}, reg); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
@@ -0,0 +1,140 @@
+package keyring
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/api"
+	"github.com/pkg/errors"
+)
+
+// AllVersions includes retired keys in label lookups.
+func AllVersions() LookupOption {
+	return func(o *lookupOptions) {
+		o.allVersions = true
+	}
+}
+
+// Retired describes a key that was replaced by Rotate.
+type Retired struct {
+	ID keys.ID `db:"id"`
+	// Successor is the key that replaced this key.
+	Successor keys.ID `db:"successor"`
+	// RetiredAt is when the key was retired (in milliseconds).
+	RetiredAt int64 `db:"retiredAt"`
+}
+
+// Rotate generates a new key of the same type as the active key with label,
+// and retires the previous key.
+// The previous key keeps the label, and can be found with AllVersions (or by
+// its ID) for verification, while the new key is returned by label lookups.
+// Requires Unlock.
+func (k *Keyring) Rotate(label string) (*api.Key, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	var out *api.Key
+	if err := Transact(k.db, func(tx *sqlx.Tx) error {
+		var ks []*api.Key
+		sqlLabel := "%^" + label + "$%"
+		if err := tx.Select(&ks, "SELECT * FROM keys WHERE labels LIKE $1"+activeClause(), sqlLabel); err != nil {
+			return err
+		}
+		if len(ks) == 0 {
+			return keys.NewErrNotFound(label)
+		}
+		if len(ks) > 1 {
+			return errors.Errorf("multiple keys for label %q", label)
+		}
+		prev := ks[0]
+
+		var gen keys.Key
+		switch keys.KeyType(prev.Type) {
+		case keys.EdX25519:
+			gen = keys.GenerateEdX25519Key()
+		case keys.X25519:
+			gen = keys.GenerateX25519Key()
+		default:
+			return errors.Errorf("unsupported key type for rotate %s", prev.Type)
+		}
+		ts := k.clock.NowMillis()
+		key := api.NewKey(gen).WithLabels(label).Created(ts)
+
+		logger.Debugf("Rotating %q from %s to %s", label, prev.ID, key.ID)
+		if _, err := setKeyTx(tx, key, k.historyLimit, ts); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO retired (id, successor, retiredAt) VALUES ($1, $2, $3)`, prev.ID, key.ID, ts); err != nil {
+			return err
+		}
+		if err := auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: AuditKeyCreate, KID: key.ID, Actor: k.actor, Detail: "rotate " + label}); err != nil {
+			return err
+		}
+		if err := auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: AuditKeyUpdate, KID: prev.ID, Actor: k.actor, Detail: "retired"}); err != nil {
+			return err
+		}
+		out = key
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Retired returns retired info for a key.
+// Returns nil if the key isn't retired.
+// Requires Unlock.
+func (k *Keyring) Retired(kid keys.ID) (*Retired, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	var retired Retired
+	if err := k.db.Get(&retired, "SELECT * FROM retired WHERE id = $1", kid); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &retired, nil
+}
+
+// labelFilter returns a SQL clause for key lookups by label.
+func (k *Keyring) labelFilter(opt ...LookupOption) string {
+	opts := newLookupOptions(opt...)
+	filter := k.filter(opt...)
+	if !opts.allVersions {
+		filter += activeClause()
+	}
+	return filter
+}
+
+// activeClause returns a SQL clause to exclude retired keys.
+func activeClause() string {
+	return ` AND NOT EXISTS (SELECT 1 FROM retired WHERE retired.id = keys.id)`
+}
+
+// deleteRetiredTx removes a (removed) key from retired keys.
+// A key it retired is retired by its successor instead, or if it was the
+// active key, is active again.
+func deleteRetiredTx(tx *sqlx.Tx, kid keys.ID) error {
+	var successor keys.ID
+	if err := tx.Get(&successor, `SELECT successor FROM retired WHERE id = ?`, kid); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+	if successor == "" {
+		if _, err := tx.Exec(`DELETE FROM retired WHERE successor = ?`, kid); err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.Exec(`UPDATE retired SET successor = ? WHERE successor = ?`, successor, kid); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM retired WHERE id = ?`, kid); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package keyring_test
+
+import (
+	"testing"
+
+	"github.com/getchill-app/keyring"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotate(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn()
+
+	sk := keys.GenerateEdX25519Key()
+	err = kr.Set(api.NewKey(sk).WithLabels("signing"))
+	require.NoError(t, err)
+
+	key, err := kr.Rotate("signing")
+	require.NoError(t, err)
+	require.Equal(t, string(keys.EdX25519), key.Type)
+	require.NotEqual(t, sk.ID(), key.ID)
+
+	active, err := kr.KeyWithLabel("signing")
+	require.NoError(t, err)
+	require.Equal(t, key.ID, active.ID)
+
+	all, err := kr.KeysWithLabel("signing", keyring.AllVersions())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(all))
+
+	retired, err := kr.Retired(sk.ID())
+	require.NoError(t, err)
+	require.Equal(t, key.ID, retired.Successor)
+	retired, err = kr.Retired(key.ID)
+	require.NoError(t, err)
+	require.Nil(t, retired)
+
+	// Retired key still available by ID
+	prev, err := kr.Key(sk.ID())
+	require.NoError(t, err)
+	require.Equal(t, sk.Private(), prev.Private)
+
+	key2, err := kr.Rotate("signing")
+	require.NoError(t, err)
+	retired, err = kr.Retired(key.ID)
+	require.NoError(t, err)
+	require.Equal(t, key2.ID, retired.Successor)
+
+	all, err = kr.KeysWithLabel("signing", keyring.AllVersions())
+	require.NoError(t, err)
+	require.Equal(t, 3, len(all))
+
+	_, err = kr.Rotate("unknown")
+	require.EqualError(t, err, "unknown not found")
+
+	bk := keys.GenerateX25519Key()
+	err = kr.Set(api.NewKey(bk).WithLabels("encrypt"))
+	require.NoError(t, err)
+	key, err = kr.Rotate("encrypt")
+	require.NoError(t, err)
+	require.Equal(t, string(keys.X25519), key.Type)
+}
+
+func TestRotateRemove(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn()
+
+	sk := keys.GenerateEdX25519Key()
+	err = kr.Set(api.NewKey(sk).WithLabels("signing"))
+	require.NoError(t, err)
+	key, err := kr.Rotate("signing")
+	require.NoError(t, err)
+	key2, err := kr.Rotate("signing")
+	require.NoError(t, err)
+
+	// Removing a retired key, its predecessor is retired by its successor
+	err = kr.Remove(key.ID)
+	require.NoError(t, err)
+	retired, err := kr.Retired(sk.ID())
+	require.NoError(t, err)
+	require.Equal(t, key2.ID, retired.Successor)
+	active, err := kr.KeyWithLabel("signing")
+	require.NoError(t, err)
+	require.Equal(t, key2.ID, active.ID)
+
+	// Removing the active key, its predecessor is active again
+	err = kr.Remove(key2.ID)
+	require.NoError(t, err)
+	retired, err = kr.Retired(sk.ID())
+	require.NoError(t, err)
+	require.Nil(t, retired)
+	active, err = kr.KeyWithLabel("signing")
+	require.NoError(t, err)
+	require.Equal(t, sk.ID(), active.ID)
+}
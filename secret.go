@@ -0,0 +1,285 @@
+package keyring
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/api"
+	"github.com/keys-pub/keys/encoding"
+	"github.com/pkg/errors"
+)
+
+// SecretKind describes the kind of secret.
+type SecretKind string
+
+// Secret kinds.
+const (
+	GenericSecret  SecretKind = "generic"
+	PasswordSecret SecretKind = "password"
+	TokenSecret    SecretKind = "token"
+	NoteSecret     SecretKind = "note"
+)
+
+// Secret is an item stored in the keyring that isn't a key, such as a
+// password, API token or secure note.
+type Secret struct {
+	ID   string     `json:"id" msgpack:"id" db:"id"`
+	Name string     `json:"name" msgpack:"name" db:"name"`
+	Kind SecretKind `json:"kind" msgpack:"kind" db:"kind"`
+
+	// Fields, for example "username", "password" or "url".
+	Fields Fields `json:"fields,omitempty" msgpack:"fields,omitempty" db:"fields"`
+
+	Labels api.Labels `json:"labels,omitempty" msgpack:"labels,omitempty" db:"labels"`
+	Notes  string     `json:"notes,omitempty" msgpack:"notes,omitempty" db:"notes"`
+
+	CreatedAt int64 `json:"cts,omitempty" msgpack:"cts,omitempty" db:"createdAt"`
+	UpdatedAt int64 `json:"uts,omitempty" msgpack:"uts,omitempty" db:"updatedAt"`
+}
+
+// NewSecret creates a secret with a random ID.
+func NewSecret(name string, kind SecretKind) *Secret {
+	return &Secret{
+		ID:     encoding.MustEncode(keys.RandBytes(32), encoding.Base62),
+		Name:   name,
+		Kind:   kind,
+		Fields: Fields{},
+	}
+}
+
+// WithField returns secret with field set.
+func (s *Secret) WithField(name string, value string) *Secret {
+	if s.Fields == nil {
+		s.Fields = Fields{}
+	}
+	s.Fields[name] = value
+	return s
+}
+
+// WithLabels returns secret with labels added.
+func (s *Secret) WithLabels(labels ...string) *Secret {
+	for _, label := range labels {
+		if s.HasLabel(label) {
+			continue
+		}
+		s.Labels = append(s.Labels, label)
+	}
+	return s
+}
+
+// HasLabel returns true if secret has label.
+func (s Secret) HasLabel(label string) bool {
+	for _, l := range s.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields for a secret.
+type Fields map[string]string
+
+// Scan for sql.DB.
+func (f *Fields) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		if v == "" {
+			*f = Fields{}
+			return nil
+		}
+		return json.Unmarshal([]byte(v), f)
+	case []byte:
+		return json.Unmarshal(v, f)
+	case nil:
+		*f = Fields{}
+		return nil
+	default:
+		return errors.Errorf("unsupported type: %T", v)
+	}
+}
+
+// Value for sql.DB.
+func (f Fields) Value() (driver.Value, error) {
+	if f == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// SetSecret adds or updates a secret.
+// Requires Unlock.
+func (k *Keyring) SetSecret(secret *Secret) error {
+	if err := k.initDB(); err != nil {
+		return err
+	}
+	if secret.ID == "" {
+		return errors.Errorf("empty secret id")
+	}
+	if secret.Kind == "" {
+		secret.Kind = GenericSecret
+	}
+	return Transact(k.db, func(tx *sqlx.Tx) error {
+		existing, err := getSecretTx(tx, secret.ID)
+		if err != nil {
+			return err
+		}
+		ts := k.clock.NowMillis()
+		op := AuditSecretCreate
+		if existing != nil {
+			op = AuditSecretUpdate
+			secret.CreatedAt = existing.CreatedAt
+		} else if secret.CreatedAt == 0 {
+			secret.CreatedAt = ts
+		}
+		secret.UpdatedAt = ts
+
+		logger.Debugf("Saving secret %s", secret.ID)
+		if _, err := tx.NamedExec(`INSERT OR REPLACE INTO secrets VALUES
+			(:id, :name, :kind, :fields, :labels, :notes, :createdAt, :updatedAt)`, secret); err != nil {
+			return err
+		}
+		return auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: op, KID: keys.ID(secret.ID), Actor: k.actor})
+	})
+}
+
+// Secret returns secret by id.
+// Returns nil if not found.
+// Requires Unlock.
+func (k *Keyring) Secret(id string) (*Secret, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	var secret Secret
+	if err := k.db.Get(&secret, "SELECT * FROM secrets WHERE id = $1", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if err := k.secretsRead(&secret); err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+// Secrets returns all secrets, ordered by name.
+// Requires Unlock.
+func (k *Keyring) Secrets() ([]*Secret, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	return k.selectSecrets("SELECT * FROM secrets ORDER BY name")
+}
+
+// SecretsWithKind returns secrets of a kind.
+// Requires Unlock.
+func (k *Keyring) SecretsWithKind(kind SecretKind) ([]*Secret, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	return k.selectSecrets("SELECT * FROM secrets WHERE kind = $1 ORDER BY name", kind)
+}
+
+// SecretsWithLabel returns secrets with label.
+// Requires Unlock.
+func (k *Keyring) SecretsWithLabel(label string) ([]*Secret, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	sqlLabel := "%^" + label + "$%"
+	return k.selectSecrets("SELECT * FROM secrets WHERE labels LIKE $1 ORDER BY name", sqlLabel)
+}
+
+// SearchSecrets returns secrets whose name, labels or notes contain the query.
+// Field values are not searched.
+// Requires Unlock.
+func (k *Keyring) SearchSecrets(query string) ([]*Secret, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	q := "%" + escapeLike(query) + "%"
+	return k.selectSecrets(`SELECT * FROM secrets WHERE
+		name LIKE $1 ESCAPE '\' OR labels LIKE $1 ESCAPE '\' OR notes LIKE $1 ESCAPE '\'
+		ORDER BY name`, q)
+}
+
+// RemoveSecret removes a secret.
+// Requires Unlock.
+func (k *Keyring) RemoveSecret(id string) error {
+	if err := k.initDB(); err != nil {
+		return err
+	}
+	return Transact(k.db, func(tx *sqlx.Tx) error {
+		logger.Debugf("Deleting secret %s", id)
+		res, err := tx.Exec(`DELETE FROM secrets WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return keys.NewErrNotFound(id)
+		}
+		return auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: AuditSecretRemove, KID: keys.ID(id), Actor: k.actor})
+	})
+}
+
+func (k *Keyring) selectSecrets(query string, args ...interface{}) ([]*Secret, error) {
+	var out []*Secret
+	if err := k.db.Select(&out, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if err := k.secretsRead(out...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (k *Keyring) secretsRead(secrets ...*Secret) error {
+	if len(secrets) == 0 {
+		return nil
+	}
+	return Transact(k.db, func(tx *sqlx.Tx) error {
+		for _, secret := range secrets {
+			if err := auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: AuditSecretRead, KID: keys.ID(secret.ID), Actor: k.actor}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func getSecretTx(tx *sqlx.Tx, id string) (*Secret, error) {
+	var secret Secret
+	if err := tx.Get(&secret, "SELECT * FROM secrets WHERE id = $1", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &secret, nil
+}
+
+func escapeLike(s string) string {
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		if r == '%' || r == '_' || r == '\\' {
+			out = append(out, '\\')
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
@@ -0,0 +1,95 @@
+package keyring_test
+
+import (
+	"testing"
+
+	"github.com/getchill-app/keyring"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecrets(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn()
+
+	db := keyring.NewSecret("Database", keyring.PasswordSecret).
+		WithField("username", "admin").
+		WithField("password", keys.RandPassword(16)).
+		WithLabels("prod")
+	err = kr.SetSecret(db)
+	require.NoError(t, err)
+
+	token := keyring.NewSecret("API token", keyring.TokenSecret).
+		WithField("token", keys.RandBase62(32)).
+		WithLabels("prod", "ci")
+	err = kr.SetSecret(token)
+	require.NoError(t, err)
+
+	note := keyring.NewSecret("Recovery", keyring.NoteSecret)
+	note.Notes = "100% in the safe"
+	err = kr.SetSecret(note)
+	require.NoError(t, err)
+
+	out, err := kr.Secret(db.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Database", out.Name)
+	require.Equal(t, keyring.PasswordSecret, out.Kind)
+	require.Equal(t, db.Fields, out.Fields)
+	require.NotEqual(t, int64(0), out.CreatedAt)
+
+	createdAt := out.CreatedAt
+
+	out, err = kr.Secret("unknown")
+	require.NoError(t, err)
+	require.Nil(t, out)
+
+	secrets, err := kr.Secrets()
+	require.NoError(t, err)
+	require.Equal(t, 3, len(secrets))
+	require.Equal(t, "API token", secrets[0].Name)
+
+	secrets, err = kr.SecretsWithLabel("prod")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(secrets))
+	secrets, err = kr.SecretsWithLabel("ci")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(secrets))
+
+	secrets, err = kr.SecretsWithKind(keyring.TokenSecret)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(secrets))
+
+	secrets, err = kr.SearchSecrets("data")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(secrets))
+	require.Equal(t, db.ID, secrets[0].ID)
+	secrets, err = kr.SearchSecrets("100%")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(secrets))
+	secrets, err = kr.SearchSecrets("%")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(secrets))
+
+	// Update
+	db.WithField("password", "newpassword")
+	err = kr.SetSecret(db)
+	require.NoError(t, err)
+	out, err = kr.Secret(db.ID)
+	require.NoError(t, err)
+	require.Equal(t, "newpassword", out.Fields["password"])
+	require.Equal(t, createdAt, out.CreatedAt)
+
+	err = kr.RemoveSecret(db.ID)
+	require.NoError(t, err)
+	err = kr.RemoveSecret(db.ID)
+	require.EqualError(t, err, db.ID+" not found")
+
+	err = kr.Lock()
+	require.NoError(t, err)
+	_, err = kr.Secrets()
+	require.EqualError(t, err, "keyring is locked")
+	err = kr.SetSecret(note)
+	require.EqualError(t, err, "keyring is locked")
+}
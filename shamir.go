@@ -0,0 +1,41 @@
+package keyring
+
+import (
+	"github.com/getchill-app/keyring/auth"
+)
+
+// RegisterShamir adds Shamir secret sharing (threshold) auth, returning count
+// shares (as paper key phrases), any threshold of which can unlock.
+// The shares are only returned here, and should be distributed (for example,
+// printed for each key holder).
+func (k *Keyring) RegisterShamir(mk *[32]byte, threshold int, count int) (*auth.Auth, []string, error) {
+	if k.db == nil {
+		return nil, nil, ErrLocked
+	}
+	reg, shares, err := k.auth.RegisterShamir(mk, threshold, count)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := k.audit(&AuditEntry{Op: AuditRegister}, reg); err != nil {
+		return nil, nil, err
+	}
+	return reg, shares, nil
+}
+
+// ShamirRecovery starts a recovery with Shamir secret sharing auth.
+// Add shares, then unlock with UnlockWithShamirRecovery.
+func (k *Keyring) ShamirRecovery() *auth.ShamirRecovery {
+	return k.auth.ShamirRecovery()
+}
+
+// UnlockWithShamirRecovery opens vault with shares from a recovery.
+func (k *Keyring) UnlockWithShamirRecovery(recovery *auth.ShamirRecovery) (*[32]byte, error) {
+	reg, mk, err := recovery.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := k.unlock(mk, reg); err != nil {
+		return nil, err
+	}
+	return mk, nil
+}
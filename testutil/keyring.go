@@ -29,6 +29,7 @@ func NewTestKeyring(t *testing.T) (*keyring.Keyring, func()) {
 		require.NoError(t, err)
 		err = os.Remove(path)
 		require.NoError(t, err)
+		_ = os.Remove(path + ".aux")
 	}
 
 	return kr, closeFn
@@ -0,0 +1,67 @@
+package keyring
+
+import (
+	"os"
+	"time"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/pkg/errors"
+)
+
+// DefaultTokenEnv is the default environment variable for
+// UnlockWithTokenFromEnv.
+const DefaultTokenEnv = "KEYRING_TOKEN"
+
+// RegisterToken adds a token, for non-interactive unlock (for example, in CI).
+// Returns the encoded token, which should be kept secret.
+// If expire is 0, the token doesn't expire.
+func (k *Keyring) RegisterToken(mk *[32]byte, expire time.Duration) (*auth.Auth, string, error) {
+	if k.db == nil {
+		return nil, "", ErrLocked
+	}
+	reg, token, err := k.auth.RegisterToken(mk, expire)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := k.audit(&AuditEntry{Op: AuditRegister}, reg); err != nil {
+		return nil, "", err
+	}
+	return reg, token, nil
+}
+
+// RevokeToken removes a token (the encoded token or its auth ID).
+func (k *Keyring) RevokeToken(token string) error {
+	if k.db == nil {
+		return ErrLocked
+	}
+	reg, err := k.auth.RevokeToken(token)
+	if err != nil {
+		return err
+	}
+	return k.audit(&AuditEntry{Op: AuditRevoke}, reg)
+}
+
+// UnlockWithToken opens vault with a token.
+func (k *Keyring) UnlockWithToken(token string) (*[32]byte, error) {
+	reg, mk, err := k.auth.Token(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.unlock(mk, reg); err != nil {
+		return nil, err
+	}
+	return mk, nil
+}
+
+// UnlockWithTokenFromEnv opens vault with a token from an environment
+// variable. If name is empty, uses DefaultTokenEnv.
+func (k *Keyring) UnlockWithTokenFromEnv(name string) (*[32]byte, error) {
+	if name == "" {
+		name = DefaultTokenEnv
+	}
+	token := os.Getenv(name)
+	if token == "" {
+		return nil, errors.Errorf("no token in %s", name)
+	}
+	return k.UnlockWithToken(token)
+}
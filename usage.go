@@ -0,0 +1,141 @@
+package keyring
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/api"
+	"github.com/keys-pub/keys/tsutil"
+	"github.com/pkg/errors"
+)
+
+// KeyInfo describes a key without private key material.
+type KeyInfo struct {
+	ID        keys.ID    `db:"id"`
+	Type      string     `db:"type"`
+	Labels    api.Labels `db:"labels"`
+	Notes     string     `db:"notes"`
+	CreatedAt int64      `db:"createdAt"`
+	UpdatedAt int64      `db:"updatedAt"`
+
+	// LastUsedAt is when the key was last used (in milliseconds), or 0 if the
+	// key was never used.
+	LastUsedAt int64 `db:"lastUsedAt"`
+	// Uses is the number of times the private key was retrieved (with Get, Key
+	// or KeyWithLabel) or used (for example, with Sign).
+	Uses int64 `db:"uses"`
+
+	// NotBefore is when the key becomes valid (in milliseconds), or 0.
+	NotBefore int64 `db:"notBefore"`
+	// ExpiresAt is when the key expires (in milliseconds), or 0.
+	ExpiresAt int64 `db:"expiresAt"`
+}
+
+const selectKeyInfo = `SELECT keys.id, keys.type, keys.labels, keys.notes, keys.createdAt, keys.updatedAt,
+	COALESCE(usage.lastUsedAt, 0) AS lastUsedAt, COALESCE(usage.uses, 0) AS uses,
+	COALESCE(validity.notBefore, 0) AS notBefore, COALESCE(validity.expiresAt, 0) AS expiresAt
+	FROM keys LEFT JOIN usage ON keys.id = usage.id LEFT JOIN validity ON keys.id = validity.id`
+
+// Info returns key info (without private key material).
+// Returns nil if not found.
+// Requires Unlock.
+func (k *Keyring) Info(kid keys.ID) (*KeyInfo, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	var info KeyInfo
+	if err := k.db.Get(&info, selectKeyInfo+" WHERE keys.id = $1", kid); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Infos returns info for all keys (without private key material).
+// Requires Unlock.
+func (k *Keyring) Infos() ([]*KeyInfo, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	return getKeyInfos(k.db, selectKeyInfo+" ORDER BY keys.id")
+}
+
+// KeysUnusedSince returns info for keys not used since the specified time.
+// Keys that were never used are included if they were created before then.
+// Requires Unlock.
+func (k *Keyring) KeysUnusedSince(t time.Time) ([]*KeyInfo, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	return getKeyInfos(k.db, selectKeyInfo+
+		" WHERE COALESCE(usage.lastUsedAt, keys.createdAt, 0) < $1 ORDER BY keys.id", tsutil.Millis(t))
+}
+
+// used records use of private key material, in the audit log and key usage.
+func (k *Keyring) used(ks ...*api.Key) error {
+	return k.usedWith(AuditKeyRead, "", ks...)
+}
+
+// listed records private key material returned by a listing, in the audit log
+// (but not the key usage).
+func (k *Keyring) listed(ks ...*api.Key) error {
+	return Transact(k.db, func(tx *sqlx.Tx) error {
+		for _, key := range ks {
+			if len(key.Private) == 0 {
+				continue
+			}
+			if err := auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: AuditKeyRead, KID: key.ID, Actor: k.actor, Detail: "list"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (k *Keyring) usedWith(op AuditOp, detail string, ks ...*api.Key) error {
+	return Transact(k.db, func(tx *sqlx.Tx) error {
+		ts := k.clock.NowMillis()
+		for _, key := range ks {
+			if len(key.Private) == 0 {
+				continue
+			}
+			if err := updateUsageTx(tx, key.ID, ts); err != nil {
+				return err
+			}
+			if err := auditTx(tx, k.auditKey, k.clock, &AuditEntry{Op: op, KID: key.ID, Actor: k.actor, Detail: detail}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func updateUsageTx(tx *sqlx.Tx, kid keys.ID, ts int64) error {
+	if _, err := tx.Exec(`INSERT INTO usage (id, lastUsedAt, uses) VALUES ($1, $2, 1)
+		ON CONFLICT(id) DO UPDATE SET lastUsedAt = $2, uses = uses + 1`, kid, ts); err != nil {
+		return err
+	}
+	return nil
+}
+
+func deleteUsageTx(tx *sqlx.Tx, kid keys.ID) error {
+	if _, err := tx.Exec(`DELETE FROM usage WHERE id = ?`, kid); err != nil {
+		return err
+	}
+	return nil
+}
+
+func getKeyInfos(db *sqlx.DB, query string, args ...interface{}) ([]*KeyInfo, error) {
+	var out []*KeyInfo
+	if err := db.Select(&out, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return out, nil
+}
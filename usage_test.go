@@ -0,0 +1,89 @@
+package keyring_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getchill-app/keyring"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/api"
+	"github.com/keys-pub/keys/tsutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsage(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn()
+
+	created := tsutil.Millis(time.Now().Add(-time.Hour))
+	sk := keys.GenerateEdX25519Key()
+	err = kr.Set(api.NewKey(sk).Created(created))
+	require.NoError(t, err)
+	bk := keys.GenerateX25519Key()
+	err = kr.Set(api.NewKey(bk).Created(created))
+	require.NoError(t, err)
+	pk := keys.GenerateEdX25519Key()
+	err = kr.Set(api.NewKey(pk.PublicKey()).Created(created))
+	require.NoError(t, err)
+
+	// Listing isn't a use, but reads of private keys are audited
+	_, err = kr.Keys()
+	require.NoError(t, err)
+	_, err = kr.KeysWithType(string(keys.EdX25519))
+	require.NoError(t, err)
+	entries, err := kr.AuditLog(keyring.AuditFilter{Op: keyring.AuditKeyRead})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(entries))
+	require.Equal(t, "list", entries[0].Detail)
+
+	info, err := kr.Info(sk.ID())
+	require.NoError(t, err)
+	require.Equal(t, int64(0), info.Uses)
+	require.Equal(t, int64(0), info.LastUsedAt)
+
+	_, err = kr.Key(sk.ID())
+	require.NoError(t, err)
+	sig, err := kr.Sign(sk.ID(), []byte("hi"))
+	require.NoError(t, err)
+	out, err := sk.PublicKey().Verify(sig)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hi"), out)
+
+	info, err = kr.Info(sk.ID())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), info.Uses)
+	require.NotEqual(t, int64(0), info.LastUsedAt)
+
+	// Public keys aren't tracked
+	_, err = kr.Key(pk.ID())
+	require.NoError(t, err)
+	info, err = kr.Info(pk.ID())
+	require.NoError(t, err)
+	require.Equal(t, int64(0), info.Uses)
+
+	sender := keys.GenerateX25519Key()
+	encrypted := keys.BoxSeal([]byte("secret"), bk.PublicKey(), sender)
+	decrypted, err := kr.BoxOpen(bk.ID(), encrypted, sender.PublicKey())
+	require.NoError(t, err)
+	require.Equal(t, []byte("secret"), decrypted)
+
+	_, err = kr.Sign(bk.ID(), []byte("hi"))
+	require.EqualError(t, err, "key "+bk.ID().String()+" is not an EdX25519 key")
+
+	unused, err := kr.KeysUnusedSince(time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(unused))
+	require.Equal(t, pk.ID(), unused[0].ID)
+
+	infos, err := kr.Infos()
+	require.NoError(t, err)
+	require.Equal(t, 3, len(infos))
+
+	err = kr.Remove(sk.ID())
+	require.NoError(t, err)
+	info, err = kr.Info(sk.ID())
+	require.NoError(t, err)
+	require.Nil(t, info)
+}
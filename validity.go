@@ -0,0 +1,200 @@
+package keyring
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/tsutil"
+)
+
+// LookupOption for key lookups.
+type LookupOption func(*lookupOptions)
+
+type lookupOptions struct {
+	includeExpired bool
+	allVersions    bool
+}
+
+func newLookupOptions(opts ...LookupOption) lookupOptions {
+	var options lookupOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	return options
+}
+
+// IncludeExpired includes keys outside their validity window (expired or not
+// yet valid) in lookups.
+func IncludeExpired() LookupOption {
+	return func(o *lookupOptions) {
+		o.includeExpired = true
+	}
+}
+
+// SetClock sets the clock, for testing.
+func (k *Keyring) SetClock(clock tsutil.Clock) {
+	k.clock = clock
+}
+
+// SetValidity sets the validity window for a key.
+// A zero notBefore or expiresAt means no limit.
+// Requires Unlock.
+func (k *Keyring) SetValidity(kid keys.ID, notBefore time.Time, expiresAt time.Time) error {
+	if err := k.initDB(); err != nil {
+		return err
+	}
+	return Transact(k.db, func(tx *sqlx.Tx) error {
+		key, err := getKeyTx(tx, kid)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return keys.NewErrNotFound(kid.String())
+		}
+		nb, exp := millis(notBefore), millis(expiresAt)
+		logger.Debugf("Set validity %s (%d, %d)", kid, nb, exp)
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO validity (id, notBefore, expiresAt) VALUES ($1, $2, $3)`, kid, nb, exp); err != nil {
+			return err
+		}
+		return auditTx(tx, k.auditKey, k.clock, &AuditEntry{
+			Op:     AuditKeyUpdate,
+			KID:    kid,
+			Actor:  k.actor,
+			Detail: fmt.Sprintf("validity (%d, %d)", nb, exp),
+		})
+	})
+}
+
+// ExpiringWithin returns info for keys that expire within the duration.
+// Keys that are already expired are not included.
+// Requires Unlock.
+func (k *Keyring) ExpiringWithin(dt time.Duration) ([]*KeyInfo, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	now := k.clock.Now()
+	return getKeyInfos(k.db, selectKeyInfo+
+		" WHERE validity.expiresAt > $1 AND validity.expiresAt <= $2 ORDER BY validity.expiresAt",
+		tsutil.Millis(now), tsutil.Millis(now.Add(dt)))
+}
+
+// PurgeExpired removes keys that expired longer than grace ago.
+// Returns the IDs of the keys removed.
+// Requires Unlock.
+func (k *Keyring) PurgeExpired(grace time.Duration) ([]keys.ID, error) {
+	if err := k.initDB(); err != nil {
+		return nil, err
+	}
+	return purgeExpired(k.db, k.auditKey, k.clock, k.clock.Now().Add(-grace), k.actor)
+}
+
+type purgeOptions struct {
+	grace    time.Duration
+	interval time.Duration
+}
+
+// SetPurgeExpired enables removing expired keys in the background while
+// unlocked, every interval, for keys that expired longer than grace ago.
+// If interval is 0, background purge is disabled.
+// Takes effect on the next Setup or Unlock.
+func (k *Keyring) SetPurgeExpired(grace time.Duration, interval time.Duration) {
+	if interval == 0 {
+		k.purge = nil
+		return
+	}
+	k.purge = &purgeOptions{grace: grace, interval: interval}
+}
+
+func (k *Keyring) startPurge() {
+	if k.purge == nil || k.purgeStop != nil {
+		return
+	}
+	if err := k.initTables(); err != nil {
+		logger.Warningf("Failed to init tables for purge: %v", err)
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	k.purgeStop, k.purgeDone = stop, done
+	// The purge uses the db (and options) it was started with, and stops before
+	// the db is closed on Lock.
+	db, key, opts, clock, actor := k.db, k.auditKey, *k.purge, k.clock, k.actor
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(opts.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := purgeExpired(db, key, clock, clock.Now().Add(-opts.grace), actor); err != nil {
+					logger.Warningf("Failed to purge expired keys: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func (k *Keyring) stopPurge() {
+	if k.purgeStop == nil {
+		return
+	}
+	close(k.purgeStop)
+	// Wait for a purge in progress, so it doesn't use the db after it's closed.
+	<-k.purgeDone
+	k.purgeStop, k.purgeDone = nil, nil
+}
+
+func purgeExpired(db *sqlx.DB, key *[32]byte, clock tsutil.Clock, before time.Time, actor string) ([]keys.ID, error) {
+	var kids []keys.ID
+	if err := Transact(db, func(tx *sqlx.Tx) error {
+		if err := tx.Select(&kids, `SELECT id FROM validity WHERE expiresAt > 0 AND expiresAt <= $1`, tsutil.Millis(before)); err != nil {
+			return err
+		}
+		for _, kid := range kids {
+			logger.Debugf("Purging expired key %s", kid)
+			if err := removeKeyTx(tx, kid); err != nil {
+				return err
+			}
+			if err := auditTx(tx, key, clock, &AuditEntry{Op: AuditKeyRemove, KID: kid, Actor: actor, Detail: "expired"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return kids, nil
+}
+
+// filter returns a SQL clause for key lookups.
+func (k *Keyring) filter(opt ...LookupOption) string {
+	opts := newLookupOptions(opt...)
+	if opts.includeExpired {
+		return ""
+	}
+	return validClause(k.clock.NowMillis())
+}
+
+// validClause returns a SQL clause to exclude keys outside their validity
+// window at the specified time.
+func validClause(validAt int64) string {
+	return fmt.Sprintf(` AND NOT EXISTS (SELECT 1 FROM validity WHERE validity.id = keys.id AND
+		((validity.expiresAt > 0 AND validity.expiresAt <= %d) OR validity.notBefore > %d))`, validAt, validAt)
+}
+
+func deleteValidityTx(tx *sqlx.Tx, kid keys.ID) error {
+	if _, err := tx.Exec(`DELETE FROM validity WHERE id = ?`, kid); err != nil {
+		return err
+	}
+	return nil
+}
+
+func millis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return tsutil.Millis(t)
+}
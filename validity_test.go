@@ -0,0 +1,108 @@
+package keyring_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getchill-app/keyring"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/keys-pub/keys/api"
+	"github.com/keys-pub/keys/tsutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidity(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn()
+
+	clock := tsutil.NewTestClock()
+	kr.SetClock(clock)
+	now := clock.Now()
+
+	sk1 := keys.GenerateEdX25519Key()
+	err = kr.Set(api.NewKey(sk1).WithLabels("test"))
+	require.NoError(t, err)
+	sk2 := keys.GenerateEdX25519Key()
+	err = kr.Set(api.NewKey(sk2).WithLabels("test"))
+	require.NoError(t, err)
+	sk3 := keys.GenerateEdX25519Key()
+	err = kr.Set(api.NewKey(sk3))
+	require.NoError(t, err)
+
+	err = kr.SetValidity(sk1.ID(), time.Time{}, now.Add(time.Hour))
+	require.NoError(t, err)
+	err = kr.SetValidity(sk3.ID(), now.Add(time.Hour), time.Time{})
+	require.NoError(t, err)
+	err = kr.SetValidity(keys.RandID("kex"), time.Time{}, now)
+	require.Error(t, err)
+
+	ks, err := kr.Keys()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(ks))
+
+	expiring, err := kr.ExpiringWithin(2 * time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(expiring))
+	require.Equal(t, sk1.ID(), expiring[0].ID)
+	require.Equal(t, tsutil.Millis(now.Add(time.Hour)), expiring[0].ExpiresAt)
+
+	clock.Add(2 * time.Hour)
+
+	key, err := kr.Get(sk1.ID())
+	require.NoError(t, err)
+	require.Nil(t, key)
+	_, err = kr.Sign(sk1.ID(), []byte("hi"))
+	require.EqualError(t, err, sk1.ID().String()+" not found")
+	key, err = kr.Get(sk1.ID(), keyring.IncludeExpired())
+	require.NoError(t, err)
+	require.NotNil(t, key)
+	key, err = kr.KeyWithLabel("test")
+	require.NoError(t, err)
+	require.Equal(t, sk2.ID(), key.ID)
+	ks, err = kr.KeysWithLabel("test", keyring.IncludeExpired())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(ks))
+	ks, err = kr.Keys()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(ks))
+
+	expiring, err = kr.ExpiringWithin(2 * time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(expiring))
+
+	purged, err := kr.PurgeExpired(2 * time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(purged))
+	purged, err = kr.PurgeExpired(time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []keys.ID{sk1.ID()}, purged)
+
+	ks, err = kr.Keys(keyring.IncludeExpired())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(ks))
+}
+
+func TestPurgeExpiredBackground(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyringWithSetup(t, "testpassword")
+	defer closeFn()
+
+	sk := keys.GenerateEdX25519Key()
+	err = kr.Set(api.NewKey(sk))
+	require.NoError(t, err)
+	err = kr.SetValidity(sk.ID(), time.Time{}, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+
+	kr.SetPurgeExpired(time.Minute, 10*time.Millisecond)
+	err = kr.Lock()
+	require.NoError(t, err)
+	_, err = kr.UnlockWithPassword("testpassword")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		ks, err := kr.Keys(keyring.IncludeExpired())
+		return err == nil && len(ks) == 0
+	}, time.Second, 10*time.Millisecond)
+}
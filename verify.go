@@ -0,0 +1,112 @@
+package keyring
+
+import (
+	"context"
+	"crypto/subtle"
+	"io"
+
+	"github.com/getchill-app/keyring/auth"
+	"github.com/keys-pub/keys"
+)
+
+// VerifyPassword verifies a password, to re-confirm it while the vault is
+// unlocked (for example, before exporting a private key).
+// The password is checked against the auth db (counting as an attempt, see
+// auth.WithBackoff), and must open the same master key as the open vault,
+// otherwise returns ErrInvalidAuth. The vault database isn't reopened.
+// If locked, returns ErrLocked.
+// A duress password (see RegisterDuressPassword) doesn't lock the auth db or
+// remove other auth methods, and only verifies if the decoy vault is open.
+// The other Verify... methods work the same way for other auth methods.
+func (k *Keyring) VerifyPassword(password string) (*auth.Auth, error) {
+	return k.verify(func() (*auth.Auth, *[32]byte, error) {
+		return k.auth.VerifyPassword(password)
+	})
+}
+
+// VerifyPaperKey verifies a paper key.
+func (k *Keyring) VerifyPaperKey(paperKey string) (*auth.Auth, error) {
+	return k.verify(func() (*auth.Auth, *[32]byte, error) {
+		return k.auth.VerifyPaperKey(paperKey)
+	})
+}
+
+// VerifyFIDO2HMACSecret verifies a FIDO2 hmac-secret (with a device touch).
+func (k *Keyring) VerifyFIDO2HMACSecret(ctx context.Context, pin string) (*auth.Auth, error) {
+	return k.verify(func() (*auth.Auth, *[32]byte, error) {
+		return k.auth.VerifyFIDO2HMACSecret(ctx, k.fido2Plugin, pin)
+	})
+}
+
+// VerifyX25519 verifies an X25519 private key.
+func (k *Keyring) VerifyX25519(key *keys.X25519Key) (*auth.Auth, error) {
+	return k.verify(func() (*auth.Auth, *[32]byte, error) {
+		return k.auth.VerifyX25519(key)
+	})
+}
+
+// VerifyKeyFile verifies a key file at path.
+func (k *Keyring) VerifyKeyFile(path string) (*auth.Auth, error) {
+	return k.verify(func() (*auth.Auth, *[32]byte, error) {
+		return k.auth.VerifyKeyFilePath(path)
+	})
+}
+
+// VerifyKeyFileReader verifies a key file from a reader.
+func (k *Keyring) VerifyKeyFileReader(r io.Reader) (*auth.Auth, error) {
+	return k.verify(func() (*auth.Auth, *[32]byte, error) {
+		return k.auth.VerifyKeyFile(r)
+	})
+}
+
+// VerifyToken verifies a token.
+func (k *Keyring) VerifyToken(token string) (*auth.Auth, error) {
+	return k.verify(func() (*auth.Auth, *[32]byte, error) {
+		return k.auth.VerifyToken(token)
+	})
+}
+
+// VerifyMultiFactor verifies multiple factors.
+func (k *Keyring) VerifyMultiFactor(ctx context.Context, factors ...*auth.FactorSecret) (*auth.Auth, error) {
+	return k.verify(func() (*auth.Auth, *[32]byte, error) {
+		return k.auth.VerifyMultiFactor(ctx, k.fido2Plugin, factors...)
+	})
+}
+
+// VerifyShamirRecovery verifies shares from a Shamir recovery (see
+// ShamirRecovery).
+func (k *Keyring) VerifyShamirRecovery(recovery *auth.ShamirRecovery) (*auth.Auth, error) {
+	return k.verify(func() (*auth.Auth, *[32]byte, error) {
+		return recovery.Verify()
+	})
+}
+
+func (k *Keyring) verify(fn func() (*auth.Auth, *[32]byte, error)) (*auth.Auth, error) {
+	if k.db == nil {
+		return nil, ErrLocked
+	}
+	reg, mk, err := fn()
+	if err != nil {
+		return nil, err
+	}
+	if k.db == nil || k.mkCheck == nil {
+		return nil, ErrLocked
+	}
+	if subtle.ConstantTimeCompare(masterKeyCheck(mk), k.mkCheck) != 1 {
+		logger.Warningf("Auth %s opens a different master key", reg.ID)
+		return nil, ErrInvalidAuth
+	}
+	if err := k.audit(&AuditEntry{Op: AuditVerify}, reg); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+// masterKeyCheck is derived from the master key, to check a master key matches
+// the open vault, without keeping the master key.
+func masterKeyCheck(mk *[32]byte) []byte {
+	if mk == nil {
+		return nil
+	}
+	return keys.HKDFSHA256(mk[:], 32, nil, []byte("keyring/verify"))
+}
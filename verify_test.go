@@ -0,0 +1,72 @@
+package keyring_test
+
+import (
+	"testing"
+
+	"github.com/getchill-app/keyring"
+	"github.com/getchill-app/keyring/testutil"
+	"github.com/keys-pub/keys"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyring(t)
+	defer closeFn()
+
+	mk, err := kr.SetupPassword("testpassword")
+	require.NoError(t, err)
+	paperKey := keys.RandPhrase()
+	reg, err := kr.RegisterPaperKey(mk, paperKey)
+	require.NoError(t, err)
+
+	_, err = kr.VerifyPassword("testpassword")
+	require.NoError(t, err)
+	out, err := kr.VerifyPaperKey(paperKey)
+	require.NoError(t, err)
+	require.Equal(t, reg.ID, out.ID)
+	require.Equal(t, keyring.Unlocked, kr.Status())
+
+	_, err = kr.VerifyPassword("invalidpassword")
+	require.EqualError(t, err, "invalid auth")
+	_, err = kr.VerifyPaperKey(keys.RandPhrase())
+	require.EqualError(t, err, "invalid auth")
+
+	entries, err := kr.AuditLog(keyring.AuditFilter{Op: keyring.AuditVerify})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, reg.ID, entries[1].AuthID)
+
+	err = kr.Lock()
+	require.NoError(t, err)
+	_, err = kr.VerifyPassword("testpassword")
+	require.Equal(t, keyring.ErrLocked, err)
+}
+
+func TestVerifyShamir(t *testing.T) {
+	var err error
+	kr, closeFn := testutil.NewTestKeyring(t)
+	defer closeFn()
+
+	mk, err := kr.SetupPassword("testpassword")
+	require.NoError(t, err)
+	reg, shares, err := kr.RegisterShamir(mk, 2, 3)
+	require.NoError(t, err)
+
+	recovery := kr.ShamirRecovery()
+	_, err = recovery.Add(shares[0])
+	require.NoError(t, err)
+	_, err = kr.VerifyShamirRecovery(recovery)
+	require.EqualError(t, err, "1 more shares needed")
+	_, err = recovery.Add(shares[2])
+	require.NoError(t, err)
+	out, err := kr.VerifyShamirRecovery(recovery)
+	require.NoError(t, err)
+	require.Equal(t, reg.ID, out.ID)
+	require.Equal(t, keyring.Unlocked, kr.Status())
+
+	err = kr.Lock()
+	require.NoError(t, err)
+	_, err = kr.VerifyShamirRecovery(recovery)
+	require.Equal(t, keyring.ErrLocked, err)
+}
@@ -0,0 +1,47 @@
+package keyring
+
+import (
+	"github.com/getchill-app/keyring/auth"
+	"github.com/keys-pub/keys"
+)
+
+// SetupX25519 setup vault with the master key sealed to an X25519 public key.
+func (k *Keyring) SetupX25519(publicKey *keys.X25519PublicKey) (*[32]byte, error) {
+	mk := keys.Rand32()
+	reg, err := k.auth.RegisterX25519(publicKey, mk)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.setup(mk, reg); err != nil {
+		return nil, err
+	}
+	return mk, nil
+}
+
+// RegisterX25519 adds an X25519 public key, granting vault access to whoever
+// has the private key.
+func (k *Keyring) RegisterX25519(mk *[32]byte, publicKey *keys.X25519PublicKey) (*auth.Auth, error) {
+	if k.db == nil {
+		return nil, ErrLocked
+	}
+	reg, err := k.auth.RegisterX25519(publicKey, mk)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.audit(&AuditEntry{Op: AuditRegister}, reg); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+// UnlockWithX25519 opens vault with an X25519 private key.
+func (k *Keyring) UnlockWithX25519(key *keys.X25519Key) (*[32]byte, error) {
+	reg, mk, err := k.auth.X25519(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.unlock(mk, reg); err != nil {
+		return nil, err
+	}
+	return mk, nil
+}